@@ -1,139 +1,333 @@
-package api
-
-import (
-	"encoding/json"
-	"log"
-	"net"
-	"net/http"
-	"os"
-	"strconv"
-
-	"github.com/ynoproject/ynorankings/database"
-)
-
-func Init() {
-	http.HandleFunc("/categories", handleCategories)
-	http.HandleFunc("/page", handlePage)
-	http.HandleFunc("/list", handleList)
-
-	http.Serve(getListener(), nil)
-}
-
-func getListener() net.Listener {
-	os.Remove("sockets/rankings.sock")
-
-	listener, err := net.Listen("unix", "sockets/rankings.sock")
-	if err != nil {
-		log.Fatal(err)
-		return nil
-	}
-
-	if err := os.Chmod("sockets/rankings.sock", 0666); err != nil {
-		log.Fatal(err)
-		return nil
-	}
-
-	return listener
-}
-
-func handleCategories(w http.ResponseWriter, r *http.Request) {
-	gameParam, ok := r.URL.Query()["game"]
-	if !ok || len(gameParam) == 0 {
-		http.Error(w, "game not specified", http.StatusBadRequest)
-		return
-	}
-
-	rankingCategories, err := database.GetRankingCategories(gameParam[0])
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	rankingCategoriesJson, err := json.Marshal(rankingCategories)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Write(rankingCategoriesJson)
-}
-
-func handlePage(w http.ResponseWriter, r *http.Request) {
-	var uuid string
-
-	token := r.Header.Get("Authorization")
-	if token != "" {
-		uuid = database.GetPlayerUuidFromToken(token)
-	}
-
-	categoryParam, ok := r.URL.Query()["category"]
-	if !ok || len(categoryParam) == 0 {
-		http.Error(w, "category not specified", http.StatusBadRequest)
-		return
-	}
-
-	subCategoryParam, ok := r.URL.Query()["subCategory"]
-	if !ok || len(subCategoryParam) == 0 {
-		http.Error(w, "subcategory not specified", http.StatusBadRequest)
-		return
-	}
-
-	playerPage := 1
-	if token != "" {
-		var err error
-		playerPage, err = database.GetRankingEntryPage(uuid, categoryParam[0], subCategoryParam[0])
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
-
-	w.Write([]byte(strconv.Itoa(playerPage)))
-}
-
-func handleList(w http.ResponseWriter, r *http.Request) {
-	gameParam, ok := r.URL.Query()["game"]
-	if !ok || len(gameParam) == 0 {
-		http.Error(w, "game not specified", http.StatusBadRequest)
-		return
-	}
-
-	categoryParam, ok := r.URL.Query()["category"]
-	if !ok || len(categoryParam) == 0 {
-		http.Error(w, "category not specified", http.StatusBadRequest)
-		return
-	}
-
-	subCategoryParam, ok := r.URL.Query()["subCategory"]
-	if !ok || len(subCategoryParam) == 0 {
-		http.Error(w, "subcategory not specified", http.StatusBadRequest)
-		return
-	}
-
-	var page int
-	pageParam, ok := r.URL.Query()["page"]
-	if !ok || len(pageParam) == 0 {
-		page = 1
-	} else {
-		pageInt, err := strconv.Atoi(pageParam[0])
-		if err != nil {
-			page = 1
-		} else {
-			page = pageInt
-		}
-	}
-
-	rankings, err := database.GetRankingsPaged(gameParam[0], categoryParam[0], subCategoryParam[0], page)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	rankingsJson, err := json.Marshal(rankings)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Write(rankingsJson)
-}
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ynoproject/ynorankings/config"
+	"github.com/ynoproject/ynorankings/database"
+	"github.com/ynoproject/ynorankings/rankings"
+)
+
+var (
+	store    database.RankingStore
+	timeouts config.TimeoutConfig
+)
+
+func Init(cfg config.Config, rankingStore database.RankingStore) {
+	store = rankingStore
+	timeouts = cfg.Timeouts
+
+	// The API gets its own mux so handlers registered on the default one by imported packages aren't served
+	mux := http.NewServeMux()
+	mux.HandleFunc("/categories", handleCategories)
+	mux.HandleFunc("/page", handlePage)
+	mux.HandleFunc("/list", handleList)
+	mux.HandleFunc("/firstUnlockers", handleFirstUnlockers)
+	mux.HandleFunc("/history", handleHistory)
+	mux.HandleFunc("/standing", handleStanding)
+	mux.HandleFunc("/stats", handleStats)
+
+	http.Serve(getListener(cfg.ListenNetwork, cfg.ListenAddress), mux)
+}
+
+func getListener(network string, address string) net.Listener {
+	if network == "unix" {
+		os.Remove(address)
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	if network == "unix" {
+		if err := os.Chmod(address, 0666); err != nil {
+			log.Fatal(err)
+			return nil
+		}
+	}
+
+	return listener
+}
+
+func handleCategories(w http.ResponseWriter, r *http.Request) {
+	gameParam, ok := r.URL.Query()["game"]
+	if !ok || len(gameParam) == 0 {
+		http.Error(w, "game not specified", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeouts.EndpointTimeout("categories"))
+	defer cancel()
+
+	rankingCategories, err := store.GetRankingCategories(ctx, gameParam[0])
+	if err != nil {
+		handleStoreError(w, err)
+		return
+	}
+
+	rankingCategoriesJson, err := json.Marshal(rankingCategories)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(rankingCategoriesJson)
+}
+
+func handlePage(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeouts.EndpointTimeout("page"))
+	defer cancel()
+
+	var uuid string
+
+	token := r.Header.Get("Authorization")
+	if token != "" {
+		var err error
+		uuid, err = store.GetPlayerUuidFromToken(ctx, token)
+		if err != nil {
+			handleStoreError(w, err)
+			return
+		}
+	}
+
+	categoryParam, ok := r.URL.Query()["category"]
+	if !ok || len(categoryParam) == 0 {
+		http.Error(w, "category not specified", http.StatusBadRequest)
+		return
+	}
+
+	subCategoryParam, ok := r.URL.Query()["subCategory"]
+	if !ok || len(subCategoryParam) == 0 {
+		http.Error(w, "subcategory not specified", http.StatusBadRequest)
+		return
+	}
+
+	playerPage := 1
+	if token != "" {
+		var err error
+		playerPage, err = store.GetRankingEntryPage(ctx, uuid, categoryParam[0], subCategoryParam[0])
+		if err != nil {
+			handleStoreError(w, err)
+			return
+		}
+	}
+
+	w.Write([]byte(strconv.Itoa(playerPage)))
+}
+
+func handleList(w http.ResponseWriter, r *http.Request) {
+	gameParam, ok := r.URL.Query()["game"]
+	if !ok || len(gameParam) == 0 {
+		http.Error(w, "game not specified", http.StatusBadRequest)
+		return
+	}
+
+	categoryParam, ok := r.URL.Query()["category"]
+	if !ok || len(categoryParam) == 0 {
+		http.Error(w, "category not specified", http.StatusBadRequest)
+		return
+	}
+
+	subCategoryParam, ok := r.URL.Query()["subCategory"]
+	if !ok || len(subCategoryParam) == 0 {
+		http.Error(w, "subcategory not specified", http.StatusBadRequest)
+		return
+	}
+
+	var page int
+	pageParam, ok := r.URL.Query()["page"]
+	if !ok || len(pageParam) == 0 {
+		page = 1
+	} else {
+		pageInt, err := strconv.Atoi(pageParam[0])
+		if err != nil {
+			page = 1
+		} else {
+			page = pageInt
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeouts.EndpointTimeout("list"))
+	defer cancel()
+
+	rankings, err := store.GetRankingsPaged(ctx, gameParam[0], categoryParam[0], subCategoryParam[0], page)
+	if err != nil {
+		handleStoreError(w, err)
+		return
+	}
+
+	rankingsJson, err := json.Marshal(rankings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(rankingsJson)
+}
+
+// handleFirstUnlockers lists the players who were first to unlock a badge, up to the places counted by the
+// firstUnlocks category
+func handleFirstUnlockers(w http.ResponseWriter, r *http.Request) {
+	badgeParam, ok := r.URL.Query()["badge"]
+	if !ok || len(badgeParam) == 0 {
+		http.Error(w, "badge not specified", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeouts.EndpointTimeout("firstUnlockers"))
+	defer cancel()
+
+	unlockers, err := store.GetBadgeFirstUnlockers(ctx, badgeParam[0])
+	if err != nil {
+		handleStoreError(w, err)
+		return
+	}
+
+	unlockersJson, err := json.Marshal(unlockers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(unlockersJson)
+}
+
+// handleStanding reports the exact position of the player owning the token, however far down the leaderboard
+func handleStanding(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		http.Error(w, "token not specified", http.StatusUnauthorized)
+		return
+	}
+
+	categoryParam, ok := r.URL.Query()["category"]
+	if !ok || len(categoryParam) == 0 {
+		http.Error(w, "category not specified", http.StatusBadRequest)
+		return
+	}
+
+	subCategoryParam, ok := r.URL.Query()["subCategory"]
+	if !ok || len(subCategoryParam) == 0 {
+		http.Error(w, "subcategory not specified", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeouts.EndpointTimeout("standing"))
+	defer cancel()
+
+	uuid, err := store.GetPlayerUuidFromToken(ctx, token)
+	if err != nil {
+		handleStoreError(w, err)
+		return
+	}
+	if uuid == "" {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	standing, err := store.GetRankingStanding(ctx, uuid, categoryParam[0], subCategoryParam[0])
+	if err != nil {
+		handleStoreError(w, err)
+		return
+	}
+
+	standingJson, err := json.Marshal(standing)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(standingJson)
+}
+
+// handleHistory lists a page of a leaderboard as it stood at the given date (YYYY-MM-DD) or RFC 3339 time
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	gameParam, ok := r.URL.Query()["game"]
+	if !ok || len(gameParam) == 0 {
+		http.Error(w, "game not specified", http.StatusBadRequest)
+		return
+	}
+
+	categoryParam, ok := r.URL.Query()["category"]
+	if !ok || len(categoryParam) == 0 {
+		http.Error(w, "category not specified", http.StatusBadRequest)
+		return
+	}
+
+	subCategoryParam, ok := r.URL.Query()["subCategory"]
+	if !ok || len(subCategoryParam) == 0 {
+		http.Error(w, "subcategory not specified", http.StatusBadRequest)
+		return
+	}
+
+	atParam, ok := r.URL.Query()["at"]
+	if !ok || len(atParam) == 0 {
+		http.Error(w, "at not specified", http.StatusBadRequest)
+		return
+	}
+
+	at, err := time.Parse(time.DateOnly, atParam[0])
+	if err != nil {
+		at, err = time.Parse(time.RFC3339, atParam[0])
+		if err != nil {
+			http.Error(w, "invalid at", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page := 1
+	if pageParam, ok := r.URL.Query()["page"]; ok && len(pageParam) > 0 {
+		if pageInt, err := strconv.Atoi(pageParam[0]); err == nil {
+			page = pageInt
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeouts.EndpointTimeout("history"))
+	defer cancel()
+
+	rankings, err := store.GetRankingsPagedAt(ctx, gameParam[0], categoryParam[0], subCategoryParam[0], page, at)
+	if err != nil {
+		handleStoreError(w, err)
+		return
+	}
+
+	rankingsJson, err := json.Marshal(rankings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(rankingsJson)
+}
+
+// handleStoreError reports timed out queries as 503 so clients know to retry later
+func handleStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrTimeout) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// handleStats serves the leaderboard counts of the update runs since startup, for monitoring how many
+// rebuilds are skipped.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	statsJson, err := json.Marshal(rankings.GetUpdateStats())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(statsJson)
+}
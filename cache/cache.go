@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ynoproject/ynorankings/categories"
+	"github.com/ynoproject/ynorankings/common"
+	"github.com/ynoproject/ynorankings/config"
+	"github.com/ynoproject/ynorankings/database"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Store caches leaderboard pages and category trees in memory in front of another RankingStore.
+// Entries are dropped as soon as a rebuild or medal update that affects them completes; the TTL only
+// bounds staleness from writes made by other processes, such as the update-rankings command.
+// Only lookups of enabled games, known categories and listed pages that find something are cached.
+type Store struct {
+	database.RankingStore
+
+	ttl      time.Duration
+	timeouts config.TimeoutConfig
+	maxPages int
+	group    singleflight.Group
+
+	mutex sync.Mutex
+	// generation is bumped on every invalidation so lookups started before it don't store stale results
+	generation int
+	pages      map[pageKey]*entry[[]*common.Ranking]
+	categories map[string]*entry[[]*common.RankingCategory]
+	// lastSweep is when expired entries were last removed
+	lastSweep time.Time
+}
+
+type pageKey struct {
+	game          string
+	categoryId    string
+	subCategoryId string
+	page          int
+}
+
+type entry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+func NewStore(store database.RankingStore, cfg config.Config) *Store {
+	return &Store{
+		RankingStore: store,
+		ttl:          time.Duration(cfg.CacheTtl),
+		timeouts:     cfg.Timeouts,
+		maxPages:     database.MaxListedRecords / cfg.PageSize,
+		pages:        make(map[pageKey]*entry[[]*common.Ranking]),
+		categories:   make(map[string]*entry[[]*common.RankingCategory]),
+		lastSweep:    time.Now(),
+	}
+}
+
+func (s *Store) GetRankingsPaged(ctx context.Context, gameName string, categoryId string, subCategoryId string, page int) (rankings []*common.Ranking, err error) {
+	_, knownCategory := categories.Get(categoryId)
+	if !slices.Contains(common.GameNames, gameName) || !knownCategory || page < 1 || page > s.maxPages {
+		return s.RankingStore.GetRankingsPaged(ctx, gameName, categoryId, subCategoryId, page)
+	}
+
+	key := pageKey{game: gameName, categoryId: categoryId, subCategoryId: subCategoryId, page: page}
+
+	return get(ctx, s, s.pages, key, "page/"+gameName+"/"+categoryId+"/"+subCategoryId+"/"+strconv.Itoa(page), s.timeouts.EndpointTimeout("list"), func(ctx context.Context) ([]*common.Ranking, error) {
+		return s.RankingStore.GetRankingsPaged(ctx, gameName, categoryId, subCategoryId, page)
+	})
+}
+
+func (s *Store) GetRankingCategories(ctx context.Context, gameName string) (rankingCategories []*common.RankingCategory, err error) {
+	if !slices.Contains(common.GameNames, gameName) {
+		return s.RankingStore.GetRankingCategories(ctx, gameName)
+	}
+
+	return get(ctx, s, s.categories, gameName, "categories/"+gameName, s.timeouts.EndpointTimeout("categories"), func(ctx context.Context) ([]*common.RankingCategory, error) {
+		return s.RankingStore.GetRankingCategories(ctx, gameName)
+	})
+}
+
+func (s *Store) UpdateRankingEntries(ctx context.Context, categoryId string, subCategoryId string, gameId string) (err error) {
+	err = s.RankingStore.UpdateRankingEntries(ctx, categoryId, subCategoryId, gameId)
+
+	// A failed rebuild leaves the previous entries in place, so the cache is still valid
+	if err == nil {
+		s.invalidateLeaderboard(categoryId, subCategoryId, gameId)
+	}
+
+	return err
+}
+
+func (s *Store) FinalizeRankingEntries(ctx context.Context, categoryId string, subCategoryId string, gameId string) (err error) {
+	err = s.RankingStore.FinalizeRankingEntries(ctx, categoryId, subCategoryId, gameId)
+
+	if err == nil {
+		s.invalidateLeaderboard(categoryId, subCategoryId, gameId)
+	}
+
+	return err
+}
+
+func (s *Store) UpdatePlayerMedals(ctx context.Context, gameName string) (err error) {
+	err = s.RankingStore.UpdatePlayerMedals(ctx, gameName)
+
+	// Medal counts are shown on every page listed for the game
+	if err == nil {
+		s.invalidate(func(key pageKey) bool {
+			return key.game == gameName
+		}, func(game string) bool {
+			return false
+		})
+	}
+
+	return err
+}
+
+func (s *Store) invalidateLeaderboard(categoryId string, subCategoryId string, gameId string) {
+	s.invalidate(func(key pageKey) bool {
+		return key.categoryId == categoryId && key.subCategoryId == subCategoryId
+	}, func(game string) bool {
+		// Subcategories without a game are listed in every game's tree
+		return gameId == "" || game == gameId
+	})
+}
+
+func (s *Store) invalidate(matchPage func(key pageKey) bool, matchCategories func(game string) bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.generation++
+
+	for key := range s.pages {
+		if matchPage(key) {
+			delete(s.pages, key)
+		}
+	}
+
+	for game := range s.categories {
+		if matchCategories(game) {
+			delete(s.categories, game)
+		}
+	}
+}
+
+// get returns the cached value for key or loads it, sharing a single load between concurrent callers. The
+// shared load isn't tied to any caller's ctx, so one giving up doesn't fail the others; each caller still
+// stops waiting once its own ctx is done. Empty results aren't cached.
+func get[K comparable, T any](ctx context.Context, s *Store, entries map[K]*entry[T], key K, flightKey string, timeout time.Duration, load func(ctx context.Context) (T, error)) (value T, err error) {
+	s.mutex.Lock()
+	if cached, ok := entries[key]; ok && time.Now().Before(cached.expires) {
+		s.mutex.Unlock()
+		return cached.value, nil
+	}
+	generation := s.generation
+	s.mutex.Unlock()
+
+	// Callers arriving after an invalidation must not join a load that started before it
+	results := s.group.DoChan(flightKey+"#"+strconv.Itoa(generation), func() (any, error) {
+		loadCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		value, err := load(loadCtx)
+		if err != nil {
+			return value, err
+		}
+
+		s.mutex.Lock()
+		if s.generation == generation && isCacheable(value) {
+			entries[key] = &entry[T]{value: value, expires: time.Now().Add(s.ttl)}
+		}
+		s.sweep()
+		s.mutex.Unlock()
+
+		return value, nil
+	})
+
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return value, result.Err
+		}
+		return result.Val.(T), nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return value, fmt.Errorf("%w: %w", database.ErrTimeout, ctx.Err())
+		}
+		return value, ctx.Err()
+	}
+}
+
+// isCacheable leaves out empty lookups, which are most likely for leaderboards that don't exist.
+func isCacheable(value any) bool {
+	switch v := value.(type) {
+	case []*common.Ranking:
+		return len(v) > 0
+	case []*common.RankingCategory:
+		return len(v) > 0
+	}
+	return true
+}
+
+// sweep removes expired entries at most once per TTL; s.mutex must be held.
+func (s *Store) sweep() {
+	now := time.Now()
+	if now.Sub(s.lastSweep) < s.ttl {
+		return
+	}
+	s.lastSweep = now
+
+	for key, cached := range s.pages {
+		if !now.Before(cached.expires) {
+			delete(s.pages, key)
+		}
+	}
+	for game, cached := range s.categories {
+		if !now.Before(cached.expires) {
+			delete(s.categories, game)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+	"github.com/ynoproject/ynorankings/config"
+	"github.com/ynoproject/ynorankings/database"
+)
+
+// fakeStore counts page lookups, optionally holding each one until release is closed.
+type fakeStore struct {
+	database.RankingStore
+
+	loads    atomic.Int32
+	started  chan struct{}
+	release  chan struct{}
+	rankings []*common.Ranking
+}
+
+func (f *fakeStore) GetRankingsPaged(ctx context.Context, gameName string, categoryId string, subCategoryId string, page int) (rankings []*common.Ranking, err error) {
+	f.loads.Add(1)
+	if f.started != nil {
+		f.started <- struct{}{}
+	}
+	if f.release != nil {
+		<-f.release
+	}
+	return f.rankings, nil
+}
+
+func (f *fakeStore) UpdateRankingEntries(ctx context.Context, categoryId string, subCategoryId string, gameId string) (err error) {
+	return nil
+}
+
+func newTestStore(t *testing.T, fake *fakeStore) *Store {
+	t.Helper()
+
+	common.GameNames = []string{"2kki"}
+
+	cfg := config.Default()
+	cfg.Timeouts.Endpoint = config.Duration(time.Second)
+
+	return NewStore(fake, cfg)
+}
+
+func TestGetRankingsPagedCaches(t *testing.T) {
+	fake := &fakeStore{rankings: []*common.Ranking{{Position: 1}}}
+	store := newTestStore(t, fake)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.GetRankingsPaged(ctx, "2kki", "bp", "all", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loads := fake.loads.Load(); loads != 1 {
+		t.Errorf("got %d loads, want 1", loads)
+	}
+
+	if err := store.UpdateRankingEntries(ctx, "bp", "all", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetRankingsPaged(ctx, "2kki", "bp", "all", 1); err != nil {
+		t.Fatal(err)
+	}
+	if loads := fake.loads.Load(); loads != 2 {
+		t.Errorf("got %d loads after a rebuild, want 2", loads)
+	}
+}
+
+func TestGetRankingsPagedSkipsUncacheableLookups(t *testing.T) {
+	tests := []struct {
+		name       string
+		game       string
+		categoryId string
+		page       int
+		rankings   []*common.Ranking
+	}{
+		{"unknown game", "zzz", "bp", 1, []*common.Ranking{{Position: 1}}},
+		{"unknown category", "2kki", "nope", 1, []*common.Ranking{{Position: 1}}},
+		{"page zero", "2kki", "bp", 0, []*common.Ranking{{Position: 1}}},
+		{"page past the listed records", "2kki", "bp", database.MaxListedRecords + 1, []*common.Ranking{{Position: 1}}},
+		{"empty page", "2kki", "bp", 1, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeStore{rankings: test.rankings}
+			store := newTestStore(t, fake)
+
+			for i := 0; i < 2; i++ {
+				if _, err := store.GetRankingsPaged(context.Background(), test.game, test.categoryId, "all", test.page); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if loads := fake.loads.Load(); loads != 2 {
+				t.Errorf("got %d loads, want 2", loads)
+			}
+			if len(store.pages) != 0 {
+				t.Errorf("got %d cached pages, want none", len(store.pages))
+			}
+		})
+	}
+}
+
+func TestGetRankingsPagedDropsLoadInvalidatedWhileInFlight(t *testing.T) {
+	fake := &fakeStore{rankings: []*common.Ranking{{Position: 1}}, started: make(chan struct{}, 1), release: make(chan struct{})}
+	store := newTestStore(t, fake)
+	ctx := context.Background()
+
+	done := make(chan error)
+	go func() {
+		_, err := store.GetRankingsPaged(ctx, "2kki", "bp", "all", 1)
+		done <- err
+	}()
+
+	<-fake.started
+	if err := store.UpdateRankingEntries(ctx, "bp", "all", ""); err != nil {
+		t.Fatal(err)
+	}
+	close(fake.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.pages) != 0 {
+		t.Fatal("a load started before the rebuild was cached")
+	}
+
+	fake.started = nil
+	if _, err := store.GetRankingsPaged(ctx, "2kki", "bp", "all", 1); err != nil {
+		t.Fatal(err)
+	}
+	if loads := fake.loads.Load(); loads != 2 {
+		t.Errorf("got %d loads, want 2", loads)
+	}
+}
+
+func TestGetRankingsPagedCallerTimeout(t *testing.T) {
+	fake := &fakeStore{rankings: []*common.Ranking{{Position: 1}}, started: make(chan struct{}, 1), release: make(chan struct{})}
+	store := newTestStore(t, fake)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := store.GetRankingsPaged(ctx, "2kki", "bp", "all", 1)
+	if !errors.Is(err, database.ErrTimeout) {
+		t.Errorf("got error %v, want %v", err, database.ErrTimeout)
+	}
+
+	// The shared load carries on for other callers
+	<-fake.started
+	close(fake.release)
+	fake.started = nil
+	if _, err := store.GetRankingsPaged(context.Background(), "2kki", "bp", "all", 1); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,39 @@
+package categories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+// badgeCompletionCategory ranks players by the share of a game's badges they own, so games with few badges
+// weigh as much as others in the overall view. Hidden badges aren't counted.
+type badgeCompletionCategory struct {
+	category
+}
+
+func init() {
+	Register(&badgeCompletionCategory{category{id: "badgeCompletion", ordinal: 21, valueType: ValueFloat, sortDirection: SortDesc, sources: badgeSources}})
+}
+
+func (c *badgeCompletionCategory) SubCategories(ctx context.Context, source Source, gameName string) ([]common.RankingSubCategory, error) {
+	return c.gameSubCategories(gameName), nil
+}
+
+// SourceQuery averages the completion of every game with badges for the "all" subcategory, counting games
+// the player has no badges in as 0.
+func (c *badgeCompletionCategory) SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any) {
+	if subCategoryId != "all" {
+		query = "SELECT a.uuid, COUNT(*) * 1.0 / (SELECT COUNT(*) FROM badges b WHERE b.game = ? AND b.hidden = 0) value, MAX(pb.timestampUnlocked) timestamp FROM playerBadges pb JOIN accounts a ON a.uuid = pb.uuid JOIN badges b ON b.badgeId = pb.badgeId WHERE b.hidden = 0 AND b.game = ? GROUP BY a.uuid"
+		args = append(args, subCategoryId, subCategoryId)
+		return query, args
+	}
+
+	playerGames := "SELECT pb.uuid, b.game, COUNT(*) count, MAX(pb.timestampUnlocked) timestamp FROM playerBadges pb JOIN badges b ON b.badgeId = pb.badgeId WHERE b.hidden = 0 GROUP BY pb.uuid, b.game"
+	games := "SELECT b.game, COUNT(*) count FROM badges b WHERE b.hidden = 0 GROUP BY b.game"
+
+	query = "SELECT a.uuid, SUM(pg.count * 1.0 / g.count) / (SELECT COUNT(DISTINCT b.game) FROM badges b WHERE b.hidden = 0) value, MAX(pg.timestamp) timestamp FROM (" + playerGames + ") pg JOIN (" + games + ") g ON g.game = pg.game JOIN accounts a ON a.uuid = pg.uuid GROUP BY a.uuid"
+
+	return query, args
+}
@@ -0,0 +1,36 @@
+package categories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+type badgeCountCategory struct {
+	category
+}
+
+func init() {
+	Register(&badgeCountCategory{category{id: "badgeCount", ordinal: 20, valueType: ValueInt, sortDirection: SortDesc, tieBreak: TieBreakSecondary, windowed: true, sources: badgeSources}})
+}
+
+func (c *badgeCountCategory) SubCategories(ctx context.Context, source Source, gameName string) ([]common.RankingSubCategory, error) {
+	return c.gameSubCategories(gameName), nil
+}
+
+// SourceQuery breaks ties on the badges' total bp.
+func (c *badgeCountCategory) SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any) {
+	query = "SELECT a.uuid, COUNT(pb.uuid) value, MAX(pb.timestampUnlocked) timestamp, SUM(b.bp) secondary FROM playerBadges pb JOIN accounts a ON a.uuid = pb.uuid JOIN badges b ON b.badgeId = pb.badgeId WHERE b.hidden = 0"
+	if subCategoryId != "all" {
+		query += " AND b.game = ?"
+		args = append(args, subCategoryId)
+	}
+	if !since.IsZero() {
+		query += " AND pb.timestampUnlocked >= ?"
+		args = append(args, since)
+	}
+	query += " GROUP BY a.uuid"
+
+	return query, args
+}
@@ -0,0 +1,46 @@
+package categories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+// badgeRarityCategory scores each badge by how many of its game's badge owners there are per owner of the badge,
+// so rarer badges are worth more while every badge is worth at least 1, and sums the scores of a player's badges.
+type badgeRarityCategory struct {
+	category
+}
+
+func init() {
+	Register(&badgeRarityCategory{category{id: "badgeRarity", ordinal: 25, valueType: ValueFloat, sortDirection: SortDesc, windowed: true, sources: badgeSources}})
+}
+
+func (c *badgeRarityCategory) SubCategories(ctx context.Context, source Source, gameName string) ([]common.RankingSubCategory, error) {
+	return c.gameSubCategories(gameName), nil
+}
+
+// SourceQuery scores badges on every unlock, even for windowed leaderboards, while only counting the player's
+// unlocks since the window started. Hidden badges aren't scored nor counted towards the owners.
+func (c *badgeRarityCategory) SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any) {
+	var gameCondition string
+	if subCategoryId != "all" {
+		gameCondition = " AND b.game = ?"
+	}
+
+	owners := "SELECT b.game, COUNT(DISTINCT pb.uuid) players FROM playerBadges pb JOIN badges b ON b.badgeId = pb.badgeId WHERE b.hidden = 0" + gameCondition + " GROUP BY b.game"
+	rarities := "SELECT pb.badgeId, o.players * 1.0 / COUNT(*) rarity FROM playerBadges pb JOIN badges b ON b.badgeId = pb.badgeId JOIN (" + owners + ") o ON o.game = b.game WHERE b.hidden = 0" + gameCondition + " GROUP BY pb.badgeId, o.players"
+
+	query = "SELECT a.uuid, SUM(r.rarity) value, MAX(pb.timestampUnlocked) timestamp FROM playerBadges pb JOIN accounts a ON a.uuid = pb.uuid JOIN badges b ON b.badgeId = pb.badgeId JOIN (" + rarities + ") r ON r.badgeId = pb.badgeId WHERE b.hidden = 0" + gameCondition
+	if gameCondition != "" {
+		args = append(args, subCategoryId, subCategoryId, subCategoryId)
+	}
+	if !since.IsZero() {
+		query += " AND pb.timestampUnlocked >= ?"
+		args = append(args, since)
+	}
+	query += " GROUP BY a.uuid"
+
+	return query, args
+}
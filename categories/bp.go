@@ -0,0 +1,38 @@
+package categories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+// badgeSources are the tables the badge categories are computed from.
+var badgeSources = []string{"playerBadges", "badges"}
+
+type bpCategory struct {
+	category
+}
+
+func init() {
+	Register(&bpCategory{category{id: "bp", ordinal: 10, valueType: ValueInt, sortDirection: SortDesc, windowed: true, sources: badgeSources}})
+}
+
+func (c *bpCategory) SubCategories(ctx context.Context, source Source, gameName string) ([]common.RankingSubCategory, error) {
+	return c.gameSubCategories(gameName), nil
+}
+
+func (c *bpCategory) SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any) {
+	query = "SELECT a.uuid, SUM(b.bp) value, MAX(pb.timestampUnlocked) timestamp FROM playerBadges pb JOIN accounts a ON a.uuid = pb.uuid JOIN badges b ON b.badgeId = pb.badgeId WHERE 1 = 1"
+	if subCategoryId != "all" {
+		query += " AND b.game = ?"
+		args = append(args, subCategoryId)
+	}
+	if !since.IsZero() {
+		query += " AND pb.timestampUnlocked >= ?"
+		args = append(args, since)
+	}
+	query += " GROUP BY a.uuid"
+
+	return query, args
+}
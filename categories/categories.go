@@ -0,0 +1,237 @@
+package categories
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+type ValueType string
+
+const (
+	ValueInt   ValueType = "Int"
+	ValueFloat ValueType = "Float"
+)
+
+type SortDirection string
+
+const (
+	SortDesc SortDirection = "DESC"
+	SortAsc  SortDirection = "ASC"
+)
+
+// TieBreak decides the order of players with the same value.
+type TieBreak string
+
+const (
+	// TieBreakEarliest shows tied players at the same position but orders them by who reached the value first
+	TieBreakEarliest TieBreak = "earliest"
+	// TieBreakShared keeps tied players at the same position for medals too
+	TieBreakShared TieBreak = "shared"
+	// TieBreakSecondary ranks tied players by a secondary metric, higher first, then by who reached it first
+	TieBreakSecondary TieBreak = "secondary"
+	// TieBreakUuid gives every player their own position, ordering tied players by uuid
+	TieBreakUuid TieBreak = "uuid"
+)
+
+// Source is the part of the store used to discover the subcategories of a category.
+type Source interface {
+	GetEventPeriodData(ctx context.Context, gameName string) (eventPeriods []*common.EventPeriod, err error)
+	GetTimeTrialMapIds(ctx context.Context) (mapIds []int, err error)
+	GetGameMinigameIds(ctx context.Context, gameName string) (minigameIds []string, err error)
+}
+
+// CategoryDefinition describes a ranking category: how it appears in each game's category tree and
+// how its leaderboards are computed.
+type CategoryDefinition interface {
+	Id() string
+	// Ordinal orders categories in the tree, lowest first
+	Ordinal() int
+	ValueType() ValueType
+	SortDirection() SortDirection
+	TieBreak() TieBreak
+	// Games restricts the category to some games; nil means every game
+	Games() []string
+	// PerGame categories belong to the game whose tree lists them rather than being shared by all games
+	PerGame() bool
+	// SeparateByGame categories get a copy per game, with the game name appended to the id
+	SeparateByGame() bool
+	// Periodic categories have a subcategory per event period ordinal
+	Periodic() bool
+	// Windowed categories also get subcategories limited to the current day, week and month
+	Windowed() bool
+	// Composite categories are computed from the leaderboards of other categories, so they're rebuilt after them
+	Composite() bool
+	// Sources lists the tables the leaderboards are computed from, so they're only rebuilt once one of them changes
+	Sources() []string
+	// AggregateSubCategories lists the subcategories of a game combining its others, so composite categories
+	// don't count them twice
+	AggregateSubCategories(gameName string) []string
+	// SourceGame is the game whose rows a leaderboard of the given subcategory game is computed from, or empty
+	// when it counts every game's
+	SourceGame(subCategoryGame string) string
+	// SubCategories lists the subcategories for a game; the category is left out of the tree when there are none
+	SubCategories(ctx context.Context, source Source, gameName string) ([]common.RankingSubCategory, error)
+	// SourceQuery returns a query yielding one (uuid, value, timestamp) row per player for a leaderboard,
+	// where timestamp is when the player reached that value, plus a secondary column with TieBreakSecondary.
+	// Unless since is zero, only rows recorded from then on are counted.
+	SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any)
+}
+
+var definitions []CategoryDefinition
+
+// Register adds a category definition to the registry, usually from an init func in the file defining it.
+func Register(definition CategoryDefinition) {
+	definitions = append(definitions, definition)
+	sort.SliceStable(definitions, func(i, j int) bool {
+		return definitions[i].Ordinal() < definitions[j].Ordinal()
+	})
+}
+
+// All returns every registered category definition in ordinal order.
+func All() []CategoryDefinition {
+	return definitions
+}
+
+// Get finds the definition for a stored category id, including ids of categories separated by game.
+func Get(categoryId string) (CategoryDefinition, bool) {
+	for _, definition := range definitions {
+		if definition.Id() == categoryId {
+			return definition, true
+		}
+		if definition.SeparateByGame() && strings.HasPrefix(categoryId, definition.Id()+"_") {
+			return definition, true
+		}
+	}
+
+	return nil, false
+}
+
+// IsEnabledForGame reports whether a category is listed in a game's tree.
+func IsEnabledForGame(definition CategoryDefinition, gameName string) bool {
+	games := definition.Games()
+	if games == nil {
+		return true
+	}
+
+	for _, game := range games {
+		if game == gameName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// category implements the static parts of CategoryDefinition.
+type category struct {
+	id             string
+	ordinal        int
+	valueType      ValueType
+	sortDirection  SortDirection
+	tieBreak       TieBreak
+	games          []string
+	perGame        bool
+	separateByGame bool
+	periodic       bool
+	windowed       bool
+	composite      bool
+	sources        []string
+}
+
+func (c *category) Id() string {
+	return c.id
+}
+
+func (c *category) Ordinal() int {
+	return c.ordinal
+}
+
+func (c *category) ValueType() ValueType {
+	return c.valueType
+}
+
+func (c *category) SortDirection() SortDirection {
+	return c.sortDirection
+}
+
+func (c *category) TieBreak() TieBreak {
+	if c.tieBreak == "" {
+		return TieBreakEarliest
+	}
+	return c.tieBreak
+}
+
+func (c *category) Games() []string {
+	return c.games
+}
+
+func (c *category) PerGame() bool {
+	return c.perGame
+}
+
+func (c *category) SeparateByGame() bool {
+	return c.separateByGame
+}
+
+func (c *category) Periodic() bool {
+	return c.periodic
+}
+
+func (c *category) Windowed() bool {
+	return c.windowed
+}
+
+func (c *category) Composite() bool {
+	return c.composite
+}
+
+func (c *category) Sources() []string {
+	return c.sources
+}
+
+func (c *category) AggregateSubCategories(gameName string) []string {
+	return nil
+}
+
+func (c *category) SourceGame(subCategoryGame string) string {
+	return subCategoryGame
+}
+
+// subCategoryGame is the game stored with the subcategories of a category listed in a game's tree.
+func (c *category) subCategoryGame(gameName string) string {
+	if c.perGame {
+		return gameName
+	}
+	return ""
+}
+
+// gameSubCategories lists the overall subcategory followed by the game's own one.
+func (c *category) gameSubCategories(gameName string) []common.RankingSubCategory {
+	return []common.RankingSubCategory{{SubCategoryId: "all"}, {SubCategoryId: gameName, Game: gameName}}
+}
+
+// eventPeriodSubCategories lists a subcategory per event period of the game, after an overall one when there
+// is more than one period.
+func (c *category) eventPeriodSubCategories(ctx context.Context, source Source, gameName string, include func(*common.EventPeriod) bool) (subCategories []common.RankingSubCategory, err error) {
+	eventPeriods, err := source.GetEventPeriodData(ctx, gameName)
+	if err != nil {
+		return subCategories, err
+	}
+
+	for _, eventPeriod := range eventPeriods {
+		if include == nil || include(eventPeriod) {
+			subCategories = append(subCategories, common.RankingSubCategory{SubCategoryId: strconv.Itoa(eventPeriod.PeriodOrdinal), Game: c.subCategoryGame(gameName)})
+		}
+	}
+
+	if len(subCategories) > 1 {
+		subCategories = append([]common.RankingSubCategory{{SubCategoryId: "all", Game: c.subCategoryGame(gameName)}}, subCategories...)
+	}
+
+	return subCategories, nil
+}
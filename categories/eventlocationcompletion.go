@@ -0,0 +1,33 @@
+package categories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+type eventLocationCompletionCategory struct {
+	category
+}
+
+func init() {
+	Register(&eventLocationCompletionCategory{category{id: "eventLocationCompletion", ordinal: 60, valueType: ValueFloat, sortDirection: SortDesc, games: []string{"2kki"}, perGame: true, periodic: true, sources: []string{"eventCompletions", "eventLocations", "playerEventLocations", "gameLocations", "gameEventPeriods", "eventPeriods"}}})
+}
+
+func (c *eventLocationCompletionCategory) SubCategories(ctx context.Context, source Source, gameName string) ([]common.RankingSubCategory, error) {
+	return c.eventPeriodSubCategories(ctx, source, gameName, nil)
+}
+
+// SourceQuery computes the share of the game's non-secret event locations each player has completed.
+func (c *eventLocationCompletionCategory) SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any) {
+	query = "SELECT a.uuid, COUNT( DISTINCT COALESCE(el.locationId, pel.locationId) ) * 1.0 / aec.count value, aect.maxTimestamp timestamp FROM eventCompletions ec JOIN accounts a ON a.uuid = ec.uuid LEFT JOIN eventLocations el ON el.id = ec.eventId AND ec.type = 0 LEFT JOIN playerEventLocations pel ON pel.id = ec.eventId AND ec.type = 1 LEFT JOIN ( SELECT gl.id, gl.secret FROM gameLocations gl ) gl ON COALESCE(el.locationId, pel.locationId) = gl.id JOIN ( SELECT COUNT( DISTINCT COALESCE(ael.locationId, apel.locationId) ) count FROM eventCompletions aec LEFT JOIN eventLocations ael ON ael.id = aec.eventId AND aec.type = 0 LEFT JOIN playerEventLocations apel ON apel.id = aec.eventId AND aec.type = 1 LEFT JOIN ( SELECT agl.id, agl.secret FROM gameLocations agl ) agl ON COALESCE(ael.locationId, apel.locationId) = agl.id JOIN gameEventPeriods agep ON agep.id = COALESCE( ael.gamePeriodId, apel.gamePeriodId ) AND agep.game = ? WHERE ( ael.locationId IS NOT NULL OR apel.locationId IS NOT NULL ) AND agl.secret = 0 ) aec JOIN ( SELECT aect.uuid, MAX(aect.timestampCompleted) maxTimestamp FROM eventCompletions aect GROUP BY aect.uuid ) aect ON aect.uuid = ec.uuid JOIN gameEventPeriods gep ON gep.id = COALESCE( el.gamePeriodId, pel.gamePeriodId ) AND gep.game = ? JOIN eventPeriods ep ON ep.id = gep.periodId"
+	args = append(args, gameId, gameId)
+	if subCategoryId != "all" {
+		query += " AND ep.periodOrdinal = ?"
+		args = append(args, subCategoryId)
+	}
+	query += " WHERE gl.secret = 0 GROUP BY a.user"
+
+	return query, args
+}
@@ -0,0 +1,53 @@
+package categories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+// eventLocationCountCategory counts completed event locations, either the shared ones or, when free, the
+// ones players generate for themselves in each game.
+type eventLocationCountCategory struct {
+	category
+	free bool
+}
+
+var eventLocationSources = []string{"eventCompletions", "eventLocations", "playerEventLocations", "gameEventPeriods", "eventPeriods"}
+
+func init() {
+	Register(&eventLocationCountCategory{category: category{id: "eventLocationCount", ordinal: 40, valueType: ValueInt, sortDirection: SortDesc, periodic: true, windowed: true, sources: eventLocationSources}})
+	Register(&eventLocationCountCategory{category: category{id: "freeEventLocationCount", ordinal: 50, valueType: ValueInt, sortDirection: SortDesc, perGame: true, separateByGame: true, periodic: true, windowed: true, sources: eventLocationSources}, free: true})
+}
+
+func (c *eventLocationCountCategory) SubCategories(ctx context.Context, source Source, gameName string) ([]common.RankingSubCategory, error) {
+	return c.eventPeriodSubCategories(ctx, source, gameName, nil)
+}
+
+func (c *eventLocationCountCategory) SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any) {
+	completionType := "0"
+	if c.free {
+		completionType = "1"
+	}
+
+	query = "SELECT ec.uuid, COUNT(ec.uuid) value, MAX(ec.timestampCompleted) timestamp FROM eventCompletions ec "
+	if subCategoryId != "all" {
+		if c.free {
+			query += "JOIN playerEventLocations el ON el.id = ec.eventId JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId AND gep.game = ? "
+			args = append(args, gameId)
+		} else {
+			query += "JOIN eventLocations el ON el.id = ec.eventId JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId "
+		}
+		query += "JOIN eventPeriods ep ON ep.id = gep.periodId AND ep.periodOrdinal = ? "
+		args = append(args, subCategoryId)
+	}
+	query += "WHERE ec.type = " + completionType
+	if !since.IsZero() {
+		query += " AND ec.timestampCompleted >= ?"
+		args = append(args, since)
+	}
+	query += " GROUP BY ec.uuid"
+
+	return query, args
+}
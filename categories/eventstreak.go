@@ -0,0 +1,52 @@
+package categories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+// eventStreakCategory ranks players by their streaks of consecutive event periods with at least one event
+// completed in any game. Each game with event periods gets its own copy, so only those games list it.
+type eventStreakCategory struct {
+	category
+}
+
+func init() {
+	Register(&eventStreakCategory{category{id: "eventStreak", ordinal: 35, valueType: ValueInt, sortDirection: SortDesc, perGame: true, separateByGame: true, sources: []string{"eventCompletions", "eventLocations", "playerEventLocations", "eventVms", "gameEventPeriods", "eventPeriods"}}})
+}
+
+// SubCategories lists the longest and current streaks, leaving the category out of games without event periods.
+func (c *eventStreakCategory) SubCategories(ctx context.Context, source Source, gameName string) (subCategories []common.RankingSubCategory, err error) {
+	eventPeriods, err := source.GetEventPeriodData(ctx, gameName)
+	if err != nil || len(eventPeriods) == 0 {
+		return subCategories, err
+	}
+
+	return []common.RankingSubCategory{{SubCategoryId: "longest", Game: gameName}, {SubCategoryId: "current", Game: gameName}}, nil
+}
+
+// SourceGame is always empty since streaks count events of every game.
+func (c *eventStreakCategory) SourceGame(subCategoryGame string) string {
+	return ""
+}
+
+// SourceQuery finds each player's streaks as the runs of period ordinals they took part in, timing a streak
+// from the first completion of its last period. A current streak is still held during the period after its
+// last one, until that period ends.
+func (c *eventStreakCategory) SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any) {
+	periods := "SELECT ec.uuid, ep.periodOrdinal, MIN(ec.timestampCompleted) timestamp FROM eventCompletions ec LEFT JOIN eventLocations el ON el.id = ec.eventId AND ec.type = 0 LEFT JOIN playerEventLocations pel ON pel.id = ec.eventId AND ec.type = 1 LEFT JOIN eventVms ev ON ev.id = ec.eventId AND ec.type = 2 JOIN gameEventPeriods gep ON gep.id = COALESCE(el.gamePeriodId, pel.gamePeriodId, ev.gamePeriodId) JOIN eventPeriods ep ON ep.id = gep.periodId WHERE ep.periodOrdinal > 0 GROUP BY ec.uuid, ep.periodOrdinal"
+	// Consecutive ordinals keep the same difference with their row number, which identifies the streak
+	streaks := "SELECT p.uuid, COUNT(*) length, MAX(p.periodOrdinal) lastPeriodOrdinal, MAX(p.timestamp) timestamp FROM (SELECT p.uuid, p.periodOrdinal, p.timestamp, p.periodOrdinal - ROW_NUMBER() OVER (PARTITION BY p.uuid ORDER BY p.periodOrdinal) streak FROM (" + periods + ") p) p GROUP BY p.uuid, p.streak"
+
+	if subCategoryId == "current" {
+		query = "SELECT s.uuid, s.length value, s.timestamp FROM (" + streaks + ") s JOIN accounts a ON a.uuid = s.uuid WHERE s.lastPeriodOrdinal >= (SELECT MAX(ep.periodOrdinal) FROM eventPeriods ep WHERE ep.startDate <= UTC_DATE()) - 1"
+		return query, args
+	}
+
+	// Players reached their longest streak when their first streak of that length did
+	query = "SELECT s.uuid, s.length value, MIN(s.timestamp) timestamp FROM (" + streaks + ") s JOIN accounts a ON a.uuid = s.uuid JOIN (SELECT s.uuid, MAX(s.length) length FROM (" + streaks + ") s GROUP BY s.uuid) ls ON ls.uuid = s.uuid AND ls.length = s.length GROUP BY s.uuid, s.length"
+
+	return query, args
+}
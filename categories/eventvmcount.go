@@ -0,0 +1,39 @@
+package categories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+type eventVmCountCategory struct {
+	category
+}
+
+func init() {
+	Register(&eventVmCountCategory{category{id: "eventVmCount", ordinal: 70, valueType: ValueInt, sortDirection: SortDesc, periodic: true, windowed: true, sources: []string{"eventCompletions", "eventVms", "gameEventPeriods", "eventPeriods"}}})
+}
+
+// SubCategories only lists the event periods that had vending machines.
+func (c *eventVmCountCategory) SubCategories(ctx context.Context, source Source, gameName string) ([]common.RankingSubCategory, error) {
+	return c.eventPeriodSubCategories(ctx, source, gameName, func(eventPeriod *common.EventPeriod) bool {
+		return eventPeriod.EnableVms
+	})
+}
+
+func (c *eventVmCountCategory) SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any) {
+	query = "SELECT ec.uuid, COUNT(ec.uuid) value, MAX(aec.timestampCompleted) timestamp FROM eventCompletions ec JOIN (SELECT aec.eventId, aec.uuid, aec.timestampCompleted FROM eventCompletions aec WHERE aec.type = 2) aec ON aec.uuid = ec.uuid AND aec.eventId = ec.eventId "
+	if subCategoryId != "all" {
+		query += "JOIN eventVms ev ON ev.id = ec.eventId JOIN gameEventPeriods gep ON gep.id = ev.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId AND ep.periodOrdinal = ? "
+		args = append(args, subCategoryId)
+	}
+	query += "WHERE ec.type = 2"
+	if !since.IsZero() {
+		query += " AND ec.timestampCompleted >= ?"
+		args = append(args, since)
+	}
+	query += " GROUP BY ec.uuid"
+
+	return query, args
+}
@@ -0,0 +1,49 @@
+package categories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+type expCategory struct {
+	category
+}
+
+func init() {
+	Register(&expCategory{category{id: "exp", ordinal: 30, valueType: ValueInt, sortDirection: SortDesc, periodic: true, windowed: true, sources: []string{"eventCompletions", "eventLocations", "eventVms", "gameEventPeriods", "eventPeriods"}}})
+}
+
+func (c *expCategory) SubCategories(ctx context.Context, source Source, gameName string) ([]common.RankingSubCategory, error) {
+	return c.eventPeriodSubCategories(ctx, source, gameName, nil)
+}
+
+func (c *expCategory) SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any) {
+	isFiltered := subCategoryId != "all"
+
+	var sinceCondition string
+	if !since.IsZero() {
+		sinceCondition = " AND ec.timestampCompleted >= ?"
+	}
+
+	query = "SELECT ec.uuid, SUM(ec.exp) value, MAX(ec.timestampCompleted) timestamp FROM (SELECT ec.uuid, ec.exp, ec.timestampCompleted FROM eventCompletions ec JOIN eventLocations el ON el.id = ec.eventId AND ec.type = 0" + sinceCondition
+	if !since.IsZero() {
+		args = append(args, since)
+	}
+	if isFiltered {
+		query += " JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId AND ep.periodOrdinal = ?"
+		args = append(args, subCategoryId)
+	}
+	query += " UNION ALL SELECT ec.uuid, ec.exp, ec.timestampCompleted FROM eventCompletions ec JOIN eventVms ev ON ev.id = ec.eventId AND ec.type = 2" + sinceCondition
+	if !since.IsZero() {
+		args = append(args, since)
+	}
+	if isFiltered {
+		query += " JOIN gameEventPeriods gep ON gep.id = ev.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId AND ep.periodOrdinal = ?"
+		args = append(args, subCategoryId)
+	}
+	query += ") ec WHERE ec.exp > 0 GROUP BY ec.uuid"
+
+	return query, args
+}
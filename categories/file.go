@@ -0,0 +1,280 @@
+package categories
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+// FileCategory is a category declared in the categories file, e.g.
+//
+//	{"id": "fishingScore", "source": "playerMinigameScores", "aggregate": "max", "column": "score",
+//	 "filters": [{"column": "minigameId", "op": "=", "value": "fishing"}], "games": ["2kki"], "perGame": true}
+//
+// Shared categories get an "all" subcategory plus one per game when the source has a game, or one per event
+// period when periodic. Per-game categories are kept apart for each game, like freeEventLocationCount.
+type FileCategory struct {
+	Id        string       `json:"id"`
+	Ordinal   int          `json:"ordinal"`
+	Source    string       `json:"source"`
+	Aggregate string       `json:"aggregate"`
+	Column    string       `json:"column"`
+	Filters   []FileFilter `json:"filters"`
+	Sort      string       `json:"sort"`
+	TieBreak  string       `json:"tieBreak"`
+	ValueType string       `json:"valueType"`
+	Games     []string     `json:"games"`
+	PerGame   bool         `json:"perGame"`
+	Periodic  bool         `json:"periodic"`
+}
+
+type FileFilter struct {
+	Column string `json:"column"`
+	Op     string `json:"op"`
+	Value  any    `json:"value"`
+}
+
+// sourceTable maps the columns a file category may use to their SQL expressions.
+type sourceTable struct {
+	from      string
+	game      string
+	timestamp string
+	columns   map[string]string
+	sources   []string
+}
+
+var sourceTables = map[string]sourceTable{
+	"playerBadges": {
+		from:      "playerBadges t JOIN badges b ON b.badgeId = t.badgeId",
+		game:      "b.game",
+		timestamp: "t.timestampUnlocked",
+		columns:   map[string]string{"badgeId": "t.badgeId", "bp": "b.bp", "hidden": "b.hidden"},
+		sources:   badgeSources,
+	},
+	"eventCompletions": {
+		from:      "eventCompletions t",
+		timestamp: "t.timestampCompleted",
+		columns:   map[string]string{"eventId": "t.eventId", "type": "t.type", "exp": "t.exp"},
+		sources:   []string{"eventCompletions"},
+	},
+	"playerTimeTrials": {
+		from:      "playerTimeTrials t",
+		timestamp: "t.timestampCompleted",
+		columns:   map[string]string{"mapId": "t.mapId", "seconds": "t.seconds"},
+		sources:   []string{"playerTimeTrials"},
+	},
+	"playerMinigameScores": {
+		from:      "playerMinigameScores t",
+		game:      "t.game",
+		timestamp: "t.timestampCompleted",
+		columns:   map[string]string{"minigameId": "t.minigameId", "score": "t.score"},
+		sources:   []string{"playerMinigameScores"},
+	},
+}
+
+var (
+	fileAggregates = map[string]string{"count": "COUNT(*)", "countDistinct": "COUNT(DISTINCT %s)", "sum": "SUM(%s)", "max": "MAX(%s)", "min": "MIN(%s)"}
+	fileFilterOps  = map[string]bool{"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+)
+
+type fileCategory struct {
+	category
+	table sourceTable
+	spec  FileCategory
+}
+
+// LoadFile validates the categories declared in a JSON file and registers them alongside the built-in ones.
+// Nothing is registered unless every category is valid.
+func LoadFile(path string, gameNames []string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("categories: %w", err)
+	}
+
+	defer file.Close()
+
+	var specs []FileCategory
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&specs); err != nil {
+		return fmt.Errorf("categories: %s: %w", path, err)
+	}
+
+	var fileCategories []*fileCategory
+	var errs []error
+
+	ids := make(map[string]bool)
+	for i, spec := range specs {
+		fileCategory, err := newFileCategory(spec, gameNames)
+		if err == nil && ids[spec.Id] {
+			err = errors.New("id is declared more than once")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("category %d (%s): %w", i, spec.Id, err))
+			continue
+		}
+
+		ids[spec.Id] = true
+		fileCategories = append(fileCategories, fileCategory)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("categories: %s:\n%w", path, errors.Join(errs...))
+	}
+
+	for _, fileCategory := range fileCategories {
+		Register(fileCategory)
+	}
+
+	return nil
+}
+
+func newFileCategory(spec FileCategory, gameNames []string) (*fileCategory, error) {
+	if spec.Id == "" || strings.ContainsAny(spec.Id, " /_") {
+		return nil, errors.New("id must be set and not contain spaces, slashes or underscores")
+	}
+	if _, ok := Get(spec.Id); ok {
+		return nil, errors.New("id is already used by another category")
+	}
+
+	table, ok := sourceTables[spec.Source]
+	if !ok {
+		return nil, fmt.Errorf("unknown source %q", spec.Source)
+	}
+
+	if _, ok := fileAggregates[spec.Aggregate]; !ok {
+		return nil, fmt.Errorf("unknown aggregate %q", spec.Aggregate)
+	}
+	if spec.Aggregate != "count" {
+		if _, ok := table.columns[spec.Column]; !ok {
+			return nil, fmt.Errorf("%s needs one of the %s columns, got %q", spec.Aggregate, spec.Source, spec.Column)
+		}
+	}
+
+	for _, filter := range spec.Filters {
+		if _, ok := table.columns[filter.Column]; !ok {
+			return nil, fmt.Errorf("filter column %q is not a %s column", filter.Column, spec.Source)
+		}
+		if !fileFilterOps[filter.Op] {
+			return nil, fmt.Errorf("unknown filter op %q", filter.Op)
+		}
+		switch filter.Value.(type) {
+		case string, float64, bool:
+		default:
+			return nil, fmt.Errorf("filter on %q needs a string, number or boolean value", filter.Column)
+		}
+	}
+
+	c := &fileCategory{table: table, spec: spec}
+	c.id = spec.Id
+	c.ordinal = spec.Ordinal
+	c.periodic = spec.Periodic
+	c.games = spec.Games
+	c.windowed = true
+	c.sources = table.sources
+	if c.periodic {
+		c.sources = append([]string{"eventPeriods"}, table.sources...)
+	}
+
+	switch spec.Sort {
+	case "", "desc":
+		c.sortDirection = SortDesc
+	case "asc":
+		c.sortDirection = SortAsc
+	default:
+		return nil, fmt.Errorf("sort must be asc or desc, got %q", spec.Sort)
+	}
+
+	switch TieBreak(spec.TieBreak) {
+	case "":
+	case TieBreakEarliest, TieBreakShared, TieBreakUuid:
+		c.tieBreak = TieBreak(spec.TieBreak)
+	default:
+		return nil, fmt.Errorf("tieBreak must be earliest, shared or uuid, got %q", spec.TieBreak)
+	}
+
+	switch spec.ValueType {
+	case "", "int":
+		c.valueType = ValueInt
+	case "float":
+		c.valueType = ValueFloat
+	default:
+		return nil, fmt.Errorf("valueType must be int or float, got %q", spec.ValueType)
+	}
+
+	if spec.PerGame {
+		if table.game == "" {
+			return nil, fmt.Errorf("%s has no game so can't be used per game", spec.Source)
+		}
+		c.perGame = true
+		c.separateByGame = true
+	}
+
+	for _, game := range spec.Games {
+		found := false
+		for _, gameName := range gameNames {
+			found = found || game == gameName
+		}
+		if !found {
+			return nil, fmt.Errorf("game %q is not enabled", game)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *fileCategory) SubCategories(ctx context.Context, source Source, gameName string) ([]common.RankingSubCategory, error) {
+	if c.periodic {
+		return c.eventPeriodSubCategories(ctx, source, gameName, nil)
+	}
+	if c.perGame {
+		return []common.RankingSubCategory{{SubCategoryId: "all", Game: gameName}}, nil
+	}
+	if c.table.game != "" {
+		return c.gameSubCategories(gameName), nil
+	}
+	return []common.RankingSubCategory{{SubCategoryId: "all"}}, nil
+}
+
+func (c *fileCategory) SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any) {
+	aggregate := fileAggregates[c.spec.Aggregate]
+	if c.spec.Aggregate != "count" {
+		aggregate = fmt.Sprintf(aggregate, c.table.columns[c.spec.Column])
+	}
+
+	query = "SELECT t.uuid, " + aggregate + " value, MAX(" + c.table.timestamp + ") timestamp FROM " + c.table.from + " JOIN accounts a ON a.uuid = t.uuid"
+	if c.periodic && subCategoryId != "all" {
+		query += " JOIN eventPeriods ep ON ep.periodOrdinal = ? AND " + c.table.timestamp + " >= ep.startDate AND " + c.table.timestamp + " < ep.endDate"
+		args = append(args, subCategoryId)
+	}
+	query += " WHERE 1 = 1"
+
+	for _, filter := range c.spec.Filters {
+		query += " AND " + c.table.columns[filter.Column] + " " + filter.Op + " ?"
+		args = append(args, filter.Value)
+	}
+
+	if c.perGame {
+		query += " AND " + c.table.game + " = ?"
+		args = append(args, gameId)
+	} else if !c.periodic && c.table.game != "" && subCategoryId != "all" {
+		query += " AND " + c.table.game + " = ?"
+		args = append(args, subCategoryId)
+	}
+
+	if !since.IsZero() {
+		query += " AND " + c.table.timestamp + " >= ?"
+		args = append(args, since)
+	}
+
+	query += " GROUP BY t.uuid"
+
+	return query, args
+}
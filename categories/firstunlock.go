@@ -0,0 +1,35 @@
+package categories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+// FirstUnlockPlaces is how many of the earliest unlockers of a badge are credited for it, with players who
+// unlocked it at the same time sharing a place.
+const FirstUnlockPlaces = 3
+
+// firstUnlockSubCategories maps each subcategory to the places it counts.
+var firstUnlockSubCategories = map[string]int{"first": 1, "top3": FirstUnlockPlaces}
+
+// firstUnlockCategory counts the badges of a game that a player was among the first to unlock.
+type firstUnlockCategory struct {
+	category
+}
+
+func init() {
+	Register(&firstUnlockCategory{category{id: "firstUnlocks", ordinal: 26, valueType: ValueInt, sortDirection: SortDesc, perGame: true, separateByGame: true, sources: badgeSources}})
+}
+
+func (c *firstUnlockCategory) SubCategories(ctx context.Context, source Source, gameName string) ([]common.RankingSubCategory, error) {
+	return []common.RankingSubCategory{{SubCategoryId: "first", Game: gameName}, {SubCategoryId: "top3", Game: gameName}}, nil
+}
+
+func (c *firstUnlockCategory) SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any) {
+	query = "SELECT u.uuid, COUNT(*) value, MAX(u.timestampUnlocked) timestamp FROM (SELECT pb.uuid, pb.timestampUnlocked, RANK() OVER (PARTITION BY pb.badgeId ORDER BY pb.timestampUnlocked) place FROM playerBadges pb JOIN badges b ON b.badgeId = pb.badgeId WHERE b.game = ?) u JOIN accounts a ON a.uuid = u.uuid WHERE u.place <= ? GROUP BY u.uuid"
+	args = append(args, gameId, firstUnlockSubCategories[subCategoryId])
+
+	return query, args
+}
@@ -0,0 +1,82 @@
+package categories
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+type minigameCategory struct {
+	category
+}
+
+func init() {
+	Register(&minigameCategory{category{id: "minigame", ordinal: 90, valueType: ValueInt, sortDirection: SortDesc, perGame: true, windowed: true, sources: []string{"playerMinigameScores"}}})
+}
+
+// MinigamePoints is what the top score of each minigame is worth on a game's combined minigame leaderboard.
+const MinigamePoints = 1000
+
+// SubCategories lists a subcategory per minigame played in the game, after one combining them all when there is
+// more than one. Subcategory ids are shared by every game, so the combined one is named after the game.
+func (c *minigameCategory) SubCategories(ctx context.Context, source Source, gameName string) (subCategories []common.RankingSubCategory, err error) {
+	minigameIds, err := source.GetGameMinigameIds(ctx, gameName)
+	if err != nil {
+		return subCategories, err
+	}
+
+	if len(minigameIds) > 1 {
+		subCategories = append(subCategories, common.RankingSubCategory{SubCategoryId: "all_" + gameName, Game: gameName})
+	}
+
+	for _, minigameId := range minigameIds {
+		subCategories = append(subCategories, common.RankingSubCategory{SubCategoryId: minigameId, Game: gameName})
+	}
+
+	return subCategories, nil
+}
+
+func (c *minigameCategory) AggregateSubCategories(gameName string) []string {
+	return []string{"all_" + gameName}
+}
+
+func (c *minigameCategory) SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any) {
+	var sinceCondition string
+	if !since.IsZero() {
+		sinceCondition = " AND ms.timestampCompleted >= ?"
+	}
+
+	if subCategoryId == "all_"+gameId {
+		return c.combinedSourceQuery(gameId, sinceCondition, since)
+	}
+
+	// Players are timed from when they first set their best score
+	query = "SELECT ms.uuid, ms.score value, MIN(ms.timestampCompleted) timestamp FROM playerMinigameScores ms JOIN (SELECT ms.uuid, MAX(ms.score) score FROM playerMinigameScores ms WHERE ms.minigameId = ?" + sinceCondition + " GROUP BY ms.uuid) bms ON bms.uuid = ms.uuid AND bms.score = ms.score WHERE ms.minigameId = ?" + sinceCondition + " GROUP BY ms.uuid, ms.score"
+	for i := 0; i < 2; i++ {
+		args = append(args, subCategoryId)
+		if !since.IsZero() {
+			args = append(args, since)
+		}
+	}
+
+	return query, args
+}
+
+// combinedSourceQuery scores each of a player's best minigame scores relative to the top score of that minigame,
+// out of MinigamePoints, and sums them. Players are timed from when they set the last of those best scores.
+func (c *minigameCategory) combinedSourceQuery(gameId string, sinceCondition string, since time.Time) (query string, args []any) {
+	bestScores := "SELECT ms.uuid, ms.minigameId, ms.score, MIN(ms.timestampCompleted) timestamp FROM playerMinigameScores ms JOIN (SELECT ms.uuid, ms.minigameId, MAX(ms.score) score FROM playerMinigameScores ms WHERE ms.game = ?" + sinceCondition + " GROUP BY ms.uuid, ms.minigameId) bms ON bms.uuid = ms.uuid AND bms.minigameId = ms.minigameId AND bms.score = ms.score WHERE ms.game = ?" + sinceCondition + " GROUP BY ms.uuid, ms.minigameId, ms.score"
+	topScores := "SELECT ms.minigameId, MAX(ms.score) score FROM playerMinigameScores ms WHERE ms.game = ?" + sinceCondition + " GROUP BY ms.minigameId"
+
+	query = "SELECT bs.uuid, SUM(FLOOR(bs.score * " + strconv.Itoa(MinigamePoints) + ".0 / ts.score)) value, MAX(bs.timestamp) timestamp FROM (" + bestScores + ") bs JOIN (" + topScores + ") ts ON ts.minigameId = bs.minigameId AND ts.score > 0 GROUP BY bs.uuid"
+	for i := 0; i < 3; i++ {
+		args = append(args, gameId)
+		if !since.IsZero() {
+			args = append(args, since)
+		}
+	}
+
+	return query, args
+}
@@ -0,0 +1,139 @@
+package categories
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+type Normalization string
+
+const (
+	// NormalizeRank scores players by how far up each leaderboard they are, from 1 for the top down towards 0
+	NormalizeRank Normalization = "rank"
+	// NormalizeValue scores players by their value relative to the best one on each leaderboard
+	NormalizeValue Normalization = "value"
+)
+
+// overallCategory combines a player's standing in other categories into a single score per game, computed
+// from their leaderboards in rankingEntries.
+type overallCategory struct {
+	category
+	normalization Normalization
+	weights       map[string]float64
+	componentIds  []string
+}
+
+// RegisterOverall adds the overall category, weighting each listed category's score. Weights of zero are ignored.
+func RegisterOverall(weights map[string]float64, normalization Normalization) error {
+	c := &overallCategory{category: category{id: "overall", ordinal: 5, valueType: ValueFloat, sortDirection: SortDesc, tieBreak: TieBreakShared, perGame: true, separateByGame: true, composite: true}, normalization: normalization, weights: weights}
+
+	for componentId, weight := range weights {
+		if weight == 0 {
+			continue
+		}
+		definition, ok := Get(componentId)
+		if !ok || definition.Id() != componentId {
+			return fmt.Errorf("overall: unknown category %q", componentId)
+		}
+		if definition.Composite() {
+			return fmt.Errorf("overall: %s can't be combined", componentId)
+		}
+		c.componentIds = append(c.componentIds, componentId)
+	}
+
+	if len(c.componentIds) == 0 {
+		return nil
+	}
+
+	sort.Strings(c.componentIds)
+	Register(c)
+
+	return nil
+}
+
+// SubCategories leaves the category out of games that none of its components are enabled for.
+func (c *overallCategory) SubCategories(ctx context.Context, source Source, gameName string) ([]common.RankingSubCategory, error) {
+	for _, componentId := range c.componentIds {
+		if definition, _ := Get(componentId); IsEnabledForGame(definition, gameName) {
+			return []common.RankingSubCategory{{SubCategoryId: "all", Game: gameName}}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// SourceQuery averages each component's score over the game's leaderboards of that category, then weights the
+// components. Periodic categories are scored on their overall leaderboard; others on the game's own leaderboards,
+// or the overall one when they have none. Players missing from a leaderboard score 0 on it.
+func (c *overallCategory) SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any) {
+	var totalWeight float64
+
+	for _, componentId := range c.componentIds {
+		definition, _ := Get(componentId)
+		if !IsEnabledForGame(definition, gameId) {
+			continue
+		}
+
+		entriesCategoryId := componentId
+		if definition.SeparateByGame() {
+			entriesCategoryId += "_" + gameId
+		}
+
+		subCategoryCondition, subCategoryArgs := componentSubCategoryCondition(definition, entriesCategoryId, gameId)
+
+		value := "e.value" + string(definition.ValueType())
+		score := "(e.total - e.position + 1) * 1.0 / e.total"
+		best := "MAX(" + value + ")"
+		if c.normalization == NormalizeValue {
+			score = "e.value * 1.0 / e.best"
+			if definition.SortDirection() == SortAsc {
+				score = "e.best * 1.0 / e.value"
+				best = "MIN(" + value + ")"
+			}
+		}
+
+		if query != "" {
+			query += " UNION ALL "
+		}
+		query += "SELECT e.uuid, ? * " + score + " / (SELECT COUNT(DISTINCT e.subCategoryId) FROM rankingEntries e WHERE e.categoryId = ? AND " + subCategoryCondition + ") score, e.timestamp FROM (SELECT e.uuid, e.position, " + value + " value, e.timestamp, COUNT(*) OVER (PARTITION BY e.subCategoryId) total, " + best + " OVER (PARTITION BY e.subCategoryId) best FROM rankingEntries e WHERE e.categoryId = ? AND " + subCategoryCondition + ") e"
+		args = append(args, c.weights[componentId], entriesCategoryId)
+		args = append(args, subCategoryArgs...)
+		args = append(args, entriesCategoryId)
+		args = append(args, subCategoryArgs...)
+
+		totalWeight += c.weights[componentId]
+	}
+
+	query = "SELECT c.uuid, SUM(c.score) / ? value, MAX(c.timestamp) timestamp FROM (" + query + ") c JOIN accounts a ON a.uuid = c.uuid GROUP BY c.uuid"
+	args = append([]any{totalWeight}, args...)
+
+	return query, args
+}
+
+// componentSubCategoryCondition selects the leaderboards of a component category scored for a game, on rows of
+// rankingEntries aliased as e. Aggregate subcategories are left out as their leaderboards are already scored.
+func componentSubCategoryCondition(definition CategoryDefinition, entriesCategoryId string, gameId string) (condition string, args []any) {
+	if definition.Periodic() {
+		return "e.subCategoryId = 'all'", nil
+	}
+
+	gameSubCategories := "SELECT rsc.subCategoryId FROM rankingSubCategories rsc WHERE rsc.categoryId = ? AND rsc.game = ? AND rsc.timeWindow = '' AND rsc.active"
+	gameArgs := []any{entriesCategoryId, gameId}
+	if aggregateIds := definition.AggregateSubCategories(gameId); len(aggregateIds) > 0 {
+		gameSubCategories += " AND rsc.subCategoryId NOT IN (?" + strings.Repeat(", ?", len(aggregateIds)-1) + ")"
+		for _, aggregateId := range aggregateIds {
+			gameArgs = append(gameArgs, aggregateId)
+		}
+	}
+
+	condition = "(e.subCategoryId IN (" + gameSubCategories + ") OR (e.subCategoryId = 'all' AND NOT EXISTS (" + gameSubCategories + ")))"
+	args = append(args, gameArgs...)
+	args = append(args, gameArgs...)
+
+	return condition, args
+}
@@ -0,0 +1,88 @@
+package categories
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+// TimeTrialPenalty is added to a player's total time for each map they haven't completed; with none, only
+// players who completed every map are ranked on the total.
+var TimeTrialPenalty time.Duration
+
+type timeTrialCategory struct {
+	category
+}
+
+func init() {
+	Register(&timeTrialCategory{category{id: "timeTrial", ordinal: 80, valueType: ValueInt, sortDirection: SortAsc, games: []string{"2kki"}, perGame: true, windowed: true, sources: []string{"playerTimeTrials"}}})
+}
+
+// SubCategories lists a subcategory per time trial map followed by the total of every map.
+func (c *timeTrialCategory) SubCategories(ctx context.Context, source Source, gameName string) (subCategories []common.RankingSubCategory, err error) {
+	mapIds, err := source.GetTimeTrialMapIds(ctx)
+	if err != nil || len(mapIds) == 0 {
+		return subCategories, err
+	}
+
+	for _, mapId := range mapIds {
+		subCategories = append(subCategories, common.RankingSubCategory{SubCategoryId: strconv.Itoa(mapId), Game: gameName})
+	}
+
+	subCategories = append(subCategories, common.RankingSubCategory{SubCategoryId: "total", Game: gameName})
+
+	return subCategories, nil
+}
+
+func (c *timeTrialCategory) AggregateSubCategories(gameName string) []string {
+	return []string{"total"}
+}
+
+func (c *timeTrialCategory) SourceQuery(subCategoryId string, gameId string, since time.Time) (query string, args []any) {
+	var sinceCondition string
+	if !since.IsZero() {
+		sinceCondition = " AND tt.timestampCompleted >= ?"
+	}
+
+	if subCategoryId == "total" {
+		return c.totalSourceQuery(sinceCondition, since)
+	}
+
+	// Players are timed from when they first set their best time
+	query = "SELECT tt.uuid, tt.seconds value, MIN(tt.timestampCompleted) timestamp FROM playerTimeTrials tt JOIN (SELECT tt.uuid, MIN(tt.seconds) seconds FROM playerTimeTrials tt WHERE tt.mapId = ?" + sinceCondition + " GROUP BY tt.uuid) btt ON btt.uuid = tt.uuid AND btt.seconds = tt.seconds WHERE tt.mapId = ?" + sinceCondition + " GROUP BY tt.uuid, tt.seconds"
+	for i := 0; i < 2; i++ {
+		args = append(args, subCategoryId)
+		if !since.IsZero() {
+			args = append(args, since)
+		}
+	}
+
+	return query, args
+}
+
+// totalSourceQuery sums the best time of every map, timing players from when they set the last of those. Windowed
+// totals only count the maps completed within the window.
+func (c *timeTrialCategory) totalSourceQuery(sinceCondition string, since time.Time) (query string, args []any) {
+	mapCount := "(SELECT COUNT(DISTINCT tt.mapId) FROM playerTimeTrials tt WHERE 1 = 1" + sinceCondition + ")"
+
+	bestTimes := "SELECT tt.uuid, tt.mapId, tt.seconds, MIN(tt.timestampCompleted) timestamp FROM playerTimeTrials tt JOIN (SELECT tt.uuid, tt.mapId, MIN(tt.seconds) seconds FROM playerTimeTrials tt WHERE 1 = 1" + sinceCondition + " GROUP BY tt.uuid, tt.mapId) btt ON btt.uuid = tt.uuid AND btt.mapId = tt.mapId AND btt.seconds = tt.seconds WHERE 1 = 1" + sinceCondition + " GROUP BY tt.uuid, tt.mapId, tt.seconds"
+
+	query = "SELECT bt.uuid, SUM(bt.seconds) + (" + mapCount + " - COUNT(*)) * ? value, MAX(bt.timestamp) timestamp FROM (" + bestTimes + ") bt GROUP BY bt.uuid"
+	if !since.IsZero() {
+		args = append(args, since)
+	}
+	args = append(args, int(TimeTrialPenalty.Seconds()))
+	if !since.IsZero() {
+		args = append(args, since, since)
+	}
+	if TimeTrialPenalty == 0 {
+		query += " HAVING COUNT(*) = " + mapCount
+		if !since.IsZero() {
+			args = append(args, since)
+		}
+	}
+
+	return query, args
+}
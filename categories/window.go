@@ -0,0 +1,75 @@
+package categories
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+// Window is a rolling period that a windowed subcategory only counts rows from, starting over at each
+// calendar boundary.
+type Window string
+
+const (
+	WindowDay   Window = "day"
+	WindowWeek  Window = "week"
+	WindowMonth Window = "month"
+)
+
+var Windows = []Window{WindowDay, WindowWeek, WindowMonth}
+
+// Start returns the start of the window containing t in UTC; weeks start on Monday.
+func (w Window) Start(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch w {
+	case WindowWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case WindowMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+
+	return day
+}
+
+// SplitWindow splits a windowed subcategory id such as "2kki_week" into the subcategory it windows and the window.
+func SplitWindow(subCategoryId string) (baseSubCategoryId string, window Window) {
+	for _, window := range Windows {
+		if baseSubCategoryId, ok := strings.CutSuffix(subCategoryId, "_"+string(window)); ok {
+			return baseSubCategoryId, window
+		}
+	}
+
+	return subCategoryId, ""
+}
+
+// ListSubCategories returns the subcategories of a category for a game, followed by a copy of each for every
+// window when the category is windowed. Event period subcategories aren't windowed, only the overall one.
+func ListSubCategories(ctx context.Context, definition CategoryDefinition, source Source, gameName string, windows []Window) (subCategories []common.RankingSubCategory, err error) {
+	subCategories, err = definition.SubCategories(ctx, source, gameName)
+	if err != nil || len(subCategories) == 0 || !definition.Windowed() {
+		return subCategories, err
+	}
+
+	var baseSubCategories []common.RankingSubCategory
+	if definition.Periodic() {
+		baseSubCategory := common.RankingSubCategory{SubCategoryId: "all"}
+		if definition.PerGame() {
+			baseSubCategory.Game = gameName
+		}
+		baseSubCategories = append(baseSubCategories, baseSubCategory)
+	} else {
+		baseSubCategories = subCategories
+	}
+
+	for _, window := range windows {
+		for _, baseSubCategory := range baseSubCategories {
+			subCategories = append(subCategories, common.RankingSubCategory{SubCategoryId: baseSubCategory.SubCategoryId + "_" + string(window), Game: baseSubCategory.Game, Window: string(window)})
+		}
+	}
+
+	return subCategories, nil
+}
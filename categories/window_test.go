@@ -0,0 +1,60 @@
+package categories
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowStart(t *testing.T) {
+	tests := []struct {
+		window Window
+		t      time.Time
+		want   time.Time
+	}{
+		{WindowDay, time.Date(2024, 3, 6, 15, 30, 0, 0, time.UTC), time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC)},
+		{WindowDay, time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC)},
+		{WindowDay, time.Date(2024, 3, 6, 23, 59, 59, 0, time.UTC), time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC)},
+		// Times are converted to UTC first
+		{WindowDay, time.Date(2024, 3, 6, 1, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60)), time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+		// Wednesday, Monday and Sunday
+		{WindowWeek, time.Date(2024, 3, 6, 12, 0, 0, 0, time.UTC), time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{WindowWeek, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{WindowWeek, time.Date(2024, 3, 10, 23, 59, 59, 0, time.UTC), time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},
+		// Weeks can start in the previous month or year
+		{WindowWeek, time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC), time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC)},
+		{WindowMonth, time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{WindowMonth, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{WindowMonth, time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC), time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		if got := test.window.Start(test.t); !got.Equal(test.want) {
+			t.Errorf("%s start of %s: got %s, want %s", test.window, test.t, got, test.want)
+		}
+	}
+}
+
+func TestSplitWindow(t *testing.T) {
+	tests := []struct {
+		subCategoryId     string
+		baseSubCategoryId string
+		window            Window
+	}{
+		{"all", "all", ""},
+		{"2kki", "2kki", ""},
+		{"2kki_week", "2kki", WindowWeek},
+		{"all_day", "all", WindowDay},
+		{"all_2kki_month", "all_2kki", WindowMonth},
+		// Only a whole window name after an underscore is split off
+		{"weekday", "weekday", ""},
+		{"day", "day", ""},
+		{"_day", "", WindowDay},
+	}
+
+	for _, test := range tests {
+		baseSubCategoryId, window := SplitWindow(test.subCategoryId)
+		if baseSubCategoryId != test.baseSubCategoryId || window != test.window {
+			t.Errorf("SplitWindow(%q): got %q, %q; want %q, %q", test.subCategoryId, baseSubCategoryId, window, test.baseSubCategoryId, test.window)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package cli
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"github.com/ynoproject/ynorankings/common"
+	"github.com/ynoproject/ynorankings/config"
 	"github.com/ynoproject/ynorankings/database"
 	"os"
 )
@@ -10,6 +13,8 @@ import (
 const (
 	CommandNone = iota
 	CommandUpdateRankings
+	CommandMigrate
+	CommandFinalizeRankings
 	CommandInvalid = -1
 )
 
@@ -18,28 +23,61 @@ type Cli struct {
 	CommandArgs []string
 }
 
-func Run() {
+func Run(cfg config.Config, store database.RankingStore) {
 	cmd := parse()
 	if cmd.Command == CommandInvalid {
 		println(`Usage:
     ynorankings # launches the server
-    ynorankings update-rankings <category> <subcategory> <game>`)
+    ynorankings update-rankings <category> <subcategory> <game>
+    ynorankings finalize-rankings <category> <subcategory> <game> # locks (or recomputes) a final leaderboard
+    ynorankings migrate up|down|status`)
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	ctx := context.Background()
+
 	var err error
 	switch cmd.Command {
 	case CommandUpdateRankings:
-		common.CurrentEventPeriodOrdinal, _ = database.GetCurrentEventPeriodOrdinal()
+		err = store.CheckSchemaVersion(ctx)
+		if err != nil {
+			break
+		}
+		if periodOrdinal, err := store.GetCurrentEventPeriodOrdinal(ctx); err == nil {
+			common.CurrentEventPeriodOrdinal.Store(int64(periodOrdinal))
+		}
 		categoryId := cmd.CommandArgs[0]
 		subcategoryId := cmd.CommandArgs[1]
 		gameId := cmd.CommandArgs[2]
-		err = database.UpdateRankingEntries(categoryId, subcategoryId, gameId)
+		updateCtx, cancel := context.WithTimeout(ctx, cfg.Timeouts.CategoryTimeout(categoryId))
+		err = store.UpdateRankingEntries(updateCtx, categoryId, subcategoryId, gameId)
+		cancel()
+	case CommandFinalizeRankings:
+		err = store.CheckSchemaVersion(ctx)
+		if err != nil {
+			break
+		}
+		categoryId := cmd.CommandArgs[0]
+		subcategoryId := cmd.CommandArgs[1]
+		gameId := cmd.CommandArgs[2]
+		finalizeCtx, cancel := context.WithTimeout(ctx, cfg.Timeouts.CategoryTimeout(categoryId))
+		err = store.FinalizeRankingEntries(finalizeCtx, categoryId, subcategoryId, gameId)
+		cancel()
+	case CommandMigrate:
+		switch cmd.CommandArgs[0] {
+		case "up":
+			err = store.MigrateUp(ctx)
+		case "down":
+			err = store.MigrateDown(ctx)
+		}
+		if err == nil {
+			err = printMigrationStatus(ctx, store)
+		}
 	}
 
 	if err != nil {
-		println(err)
+		println(err.Error())
 		os.Exit(1)
 	} else if cmd.Command != CommandNone {
 		os.Exit(0)
@@ -47,9 +85,6 @@ func Run() {
 }
 
 func parse() (flags Cli) {
-	// no flags yet
-	flag.Parse()
-
 	args := flag.Args()
 	if len(args) == 0 {
 		return
@@ -61,6 +96,19 @@ func parse() (flags Cli) {
 			flags.Command = CommandUpdateRankings
 			flags.CommandArgs = args[1:]
 		}
+	case "finalize-rankings":
+		if len(args[1:]) == 3 {
+			flags.Command = CommandFinalizeRankings
+			flags.CommandArgs = args[1:]
+		}
+	case "migrate":
+		if len(args[1:]) == 1 {
+			switch args[1] {
+			case "up", "down", "status":
+				flags.Command = CommandMigrate
+				flags.CommandArgs = args[1:]
+			}
+		}
 	}
 
 	if flags.Command == CommandNone {
@@ -69,3 +117,20 @@ func parse() (flags Cli) {
 
 	return
 }
+
+func printMigrationStatus(ctx context.Context, store database.RankingStore) error {
+	statuses, err := store.GetMigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied {
+			state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, state)
+	}
+
+	return nil
+}
@@ -1,15 +1,21 @@
 package common
 
 import (
+	"sync/atomic"
 	"time"
 )
 
 var (
-	GameNames                 = []string{"2kki", "amillusion", "braingirl", "deepdreams", "flow", "genie", "if", "mikan", "muma", "nostalgic", "oversomnia", "prayers", "sheawaits", "someday", "tsushin", "ultraviolet", "unaccomplished", "unconscious", "unevendream", "yume"}
-	GameRankingCategories     = make(map[string][]*RankingCategory)
-	CurrentEventPeriodOrdinal = -1
+	GameNames             []string
+	GameRankingCategories = make(map[string][]*RankingCategory)
+	// CurrentEventPeriodOrdinal is -1 until it's known; it's updated while leaderboards are being rebuilt
+	CurrentEventPeriodOrdinal atomic.Int64
 )
 
+func init() {
+	CurrentEventPeriodOrdinal.Store(-1)
+}
+
 type EventPeriod struct {
 	PeriodOrdinal int       `json:"periodOrdinal"`
 	EndDate       time.Time `json:"endDate"`
@@ -22,12 +28,15 @@ type RankingCategory struct {
 	SubCategories  []RankingSubCategory `json:"subCategories"`
 	Periodic       bool                 `json:"periodic"`
 	SeparateByGame bool                 `json:"-"`
+	Composite      bool                 `json:"-"`
 }
 
 type RankingSubCategory struct {
 	SubCategoryId string `json:"subCategoryId"`
 	Game          string `json:"game"`
 	PageCount     int    `json:"pageCount"`
+	// Window is set on subcategories limited to the current day, week or month
+	Window string `json:"window"`
 }
 
 type Ranking struct {
@@ -39,6 +48,43 @@ type Ranking struct {
 	Medals     [5]int  `json:"medals"`
 	ValueInt   int     `json:"valueInt"`
 	ValueFloat float32 `json:"valueFloat"`
+	// Position changes are positive when the player moved up and zero when they weren't listed before
+	PreviousPosition   int  `json:"previousPosition"`
+	PositionChange     int  `json:"positionChange"`
+	DayPositionChange  int  `json:"dayPositionChange"`
+	WeekPositionChange int  `json:"weekPositionChange"`
+	New                bool `json:"new"`
+}
+
+// RankingStanding is where a player stands on a whole leaderboard, including past the listed records.
+// Percentile is the share of players placed at or above them, so 12 means top 12%; Position is 0 when unranked.
+type RankingStanding struct {
+	Position   int     `json:"position"`
+	Total      int     `json:"total"`
+	Percentile float32 `json:"percentile"`
+	// Tier is the medal for top 100 positions, otherwise the top percentile bracket such as "top5"
+	Tier string `json:"tier"`
+}
+
+// UpdateStats counts the leaderboards that update runs rebuilt, skipped because their sources hadn't changed,
+// or failed to rebuild.
+type UpdateStats struct {
+	Runs    int64     `json:"runs"`
+	Rebuilt int64     `json:"rebuilt"`
+	Skipped int64     `json:"skipped"`
+	Failed  int64     `json:"failed"`
+	LastRun time.Time `json:"lastRun"`
+}
+
+// BadgeUnlocker is one of the first players to unlock a badge, placed by when they unlocked it.
+type BadgeUnlocker struct {
+	Position   int       `json:"position"`
+	Name       string    `json:"name"`
+	Rank       int       `json:"rank"`
+	Badge      string    `json:"badge"`
+	SystemName string    `json:"systemName"`
+	Medals     [5]int    `json:"medals"`
+	Timestamp  time.Time `json:"timestamp"`
 }
 
 type RankingEntry struct {
@@ -50,4 +96,9 @@ type RankingEntry struct {
 	ValueInt       int
 	ValueFloat     float32
 	Timestamp      time.Time
+	// Positions held on the previous leaderboard and 24h and 7d ago, zero when not listed then
+	PreviousPosition int
+	DayPosition      int
+	WeekPosition     int
+	New              bool
 }
@@ -0,0 +1,386 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const envPrefix = "YNORANKINGS_"
+
+type Config struct {
+	Database       DatabaseConfig `json:"database"`
+	ListenNetwork  string         `json:"listenNetwork"`
+	ListenAddress  string         `json:"listenAddress"`
+	PageSize       int            `json:"pageSize"`
+	UpdateInterval Duration       `json:"updateInterval"`
+	CacheTtl       Duration       `json:"cacheTtl"`
+	Games          []string       `json:"games"`
+	Timeouts       TimeoutConfig  `json:"timeouts"`
+	// CategoriesFile optionally declares extra ranking categories, see categories.FileCategory
+	CategoriesFile string `json:"categoriesFile"`
+	// SnapshotRetention is how long daily leaderboard snapshots are kept; zero disables them
+	SnapshotRetention Duration `json:"snapshotRetention"`
+	// WindowUpdateIntervals sets how often the day, week and month leaderboards are rebuilt; zero disables one.
+	// Windows left out keep their default interval.
+	WindowUpdateIntervals map[string]Duration `json:"windowUpdateIntervals"`
+	Overall               OverallConfig       `json:"overall"`
+	// UpdateConcurrency is how many leaderboards are rebuilt at once, across all update jobs
+	UpdateConcurrency int `json:"updateConcurrency"`
+	// TimeTrialPenalty is added to the total time trial time for each map not completed; zero leaves players
+	// who haven't completed every map off that leaderboard
+	TimeTrialPenalty Duration `json:"timeTrialPenalty"`
+}
+
+// OverallConfig weights the categories combined into each game's overall leaderboard, normalizing a player's
+// standing in each by rank or by value. The overall leaderboard is left out when every weight is zero. Weights
+// replace the defaults as a whole, so categories left out of a configured set aren't combined.
+type OverallConfig struct {
+	Normalization string             `json:"normalization"`
+	Weights       map[string]float64 `json:"weights"`
+}
+
+// DatabaseConfig holds the connection settings; zero pool limits keep the database/sql defaults.
+// When ReplicaDsn is set, API reads go to that MySQL replica while it lags less than ReplicaMaxLag.
+type DatabaseConfig struct {
+	Driver               string   `json:"driver"`
+	Dsn                  string   `json:"dsn"`
+	MaxOpenConns         int      `json:"maxOpenConns"`
+	MaxIdleConns         int      `json:"maxIdleConns"`
+	ConnMaxLifetime      Duration `json:"connMaxLifetime"`
+	ReplicaDsn           string   `json:"replicaDsn"`
+	ReplicaMaxLag        Duration `json:"replicaMaxLag"`
+	ReplicaCheckInterval Duration `json:"replicaCheckInterval"`
+}
+
+// TimeoutConfig bounds database work, with optional overrides per API endpoint (e.g. "list") and per category id.
+type TimeoutConfig struct {
+	Endpoint   Duration            `json:"endpoint"`
+	Endpoints  map[string]Duration `json:"endpoints"`
+	Category   Duration            `json:"category"`
+	Categories map[string]Duration `json:"categories"`
+}
+
+func (t TimeoutConfig) EndpointTimeout(endpoint string) time.Duration {
+	if timeout, ok := t.Endpoints[endpoint]; ok {
+		return time.Duration(timeout)
+	}
+	return time.Duration(t.Endpoint)
+}
+
+func (t TimeoutConfig) CategoryTimeout(categoryId string) time.Duration {
+	if timeout, ok := t.Categories[categoryId]; ok {
+		return time.Duration(timeout)
+	}
+	return time.Duration(t.Category)
+}
+
+// Duration is a time.Duration written as a string such as "15m" in the config file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"15m\": %w", err)
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(duration)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// defaultOverallWeights are used when no overall weights are configured. They're applied after loading rather
+// than in Default since decoding a JSON object into a map merges it with the existing entries.
+var defaultOverallWeights = map[string]float64{"bp": 1, "badgeCount": 1, "exp": 1, "timeTrial": 1, "minigame": 1}
+
+func Default() Config {
+	return Config{
+		Database: DatabaseConfig{
+			Driver:               "mysql",
+			Dsn:                  "yno@unix(/run/mysqld/mysqld.sock)/ynodb?parseTime=true",
+			ReplicaMaxLag:        Duration(30 * time.Second),
+			ReplicaCheckInterval: Duration(10 * time.Second),
+		},
+		ListenNetwork:  "unix",
+		ListenAddress:  "sockets/rankings.sock",
+		PageSize:       25,
+		UpdateInterval: Duration(15 * time.Minute),
+		CacheTtl:       Duration(time.Hour),
+		Timeouts: TimeoutConfig{
+			Endpoint: Duration(10 * time.Second),
+			Category: Duration(5 * time.Minute),
+		},
+		SnapshotRetention: Duration(365 * 24 * time.Hour),
+		WindowUpdateIntervals: map[string]Duration{
+			"day":   Duration(15 * time.Minute),
+			"week":  Duration(time.Hour),
+			"month": Duration(3 * time.Hour),
+		},
+		UpdateConcurrency: 4,
+		Overall: OverallConfig{
+			Normalization: "rank",
+		},
+		Games: []string{"2kki", "amillusion", "braingirl", "deepdreams", "flow", "genie", "if", "mikan", "muma", "nostalgic", "oversomnia", "prayers", "sheawaits", "someday", "tsushin", "ultraviolet", "unaccomplished", "unconscious", "unevendream", "yume"},
+	}
+}
+
+// Load builds the configuration from the defaults, the config file, YNORANKINGS_* environment variables and
+// command line flags, each overriding the previous one. It parses the command line as a side effect.
+func Load() (config Config, err error) {
+	config = Default()
+
+	configPath := flag.String("config", os.Getenv(envPrefix+"CONFIG"), "path to a JSON config file")
+	driver := flag.String("db-driver", "", "database driver (mysql or sqlite)")
+	dsn := flag.String("dsn", "", "database DSN, or file path for sqlite")
+	listenNetwork := flag.String("listen-network", "", "network to serve the API on (unix or tcp)")
+	listenAddress := flag.String("listen-address", "", "socket path or host:port to serve the API on")
+	games := flag.String("games", "", "comma-separated list of enabled games")
+	flag.Parse()
+
+	if *configPath != "" {
+		if err := config.readFile(*configPath); err != nil {
+			return config, err
+		}
+	}
+
+	if err := config.readEnv(); err != nil {
+		return config, err
+	}
+
+	setString(&config.Database.Driver, *driver)
+	setString(&config.Database.Dsn, *dsn)
+	setString(&config.ListenNetwork, *listenNetwork)
+	setString(&config.ListenAddress, *listenAddress)
+	if *games != "" {
+		config.Games = splitList(*games)
+	}
+
+	if config.Overall.Weights == nil {
+		config.Overall.Weights = maps.Clone(defaultOverallWeights)
+	}
+
+	return config, config.Validate()
+}
+
+func (c *Config) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (c *Config) readEnv() (err error) {
+	setString(&c.Database.Driver, os.Getenv(envPrefix+"DB_DRIVER"))
+	setString(&c.Database.Dsn, os.Getenv(envPrefix+"DB_DSN"))
+	setString(&c.Database.ReplicaDsn, os.Getenv(envPrefix+"DB_REPLICA_DSN"))
+	setString(&c.ListenNetwork, os.Getenv(envPrefix+"LISTEN_NETWORK"))
+	setString(&c.ListenAddress, os.Getenv(envPrefix+"LISTEN_ADDRESS"))
+	setString(&c.CategoriesFile, os.Getenv(envPrefix+"CATEGORIES_FILE"))
+	if games := os.Getenv(envPrefix + "GAMES"); games != "" {
+		c.Games = splitList(games)
+	}
+
+	ints := map[string]*int{
+		"DB_MAX_OPEN_CONNS":  &c.Database.MaxOpenConns,
+		"DB_MAX_IDLE_CONNS":  &c.Database.MaxIdleConns,
+		"PAGE_SIZE":          &c.PageSize,
+		"UPDATE_CONCURRENCY": &c.UpdateConcurrency,
+	}
+	for name, field := range ints {
+		value := os.Getenv(envPrefix + name)
+		if value == "" {
+			continue
+		}
+		if *field, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("config: %s%s: %w", envPrefix, name, err)
+		}
+	}
+
+	durations := map[string]*Duration{
+		"DB_CONN_MAX_LIFETIME": &c.Database.ConnMaxLifetime,
+		"DB_REPLICA_MAX_LAG":   &c.Database.ReplicaMaxLag,
+		"UPDATE_INTERVAL":      &c.UpdateInterval,
+		"CACHE_TTL":            &c.CacheTtl,
+		"SNAPSHOT_RETENTION":   &c.SnapshotRetention,
+		"TIME_TRIAL_PENALTY":   &c.TimeTrialPenalty,
+		"ENDPOINT_TIMEOUT":     &c.Timeouts.Endpoint,
+		"CATEGORY_TIMEOUT":     &c.Timeouts.Category,
+	}
+	for name, field := range durations {
+		value := os.Getenv(envPrefix + name)
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("config: %s%s: %w", envPrefix, name, err)
+		}
+		*field = Duration(duration)
+	}
+
+	return nil
+}
+
+// Validate reports every invalid setting at once so a bad deployment can be fixed in one go.
+func (c Config) Validate() error {
+	var errs []error
+
+	switch c.Database.Driver {
+	case "mysql", "sqlite":
+	default:
+		errs = append(errs, fmt.Errorf("database.driver must be mysql or sqlite, got %q", c.Database.Driver))
+	}
+	if c.Database.Dsn == "" {
+		errs = append(errs, errors.New("database.dsn must not be empty"))
+	}
+	if c.Database.MaxOpenConns < 0 {
+		errs = append(errs, errors.New("database.maxOpenConns must not be negative"))
+	}
+	if c.Database.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("database.maxIdleConns must not be negative"))
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("database.connMaxLifetime must not be negative"))
+	}
+	if c.Database.ReplicaDsn != "" {
+		if c.Database.Driver != "mysql" {
+			errs = append(errs, errors.New("database.replicaDsn is only supported with the mysql driver"))
+		}
+		if c.Database.ReplicaMaxLag <= 0 {
+			errs = append(errs, errors.New("database.replicaMaxLag must be positive"))
+		}
+		if c.Database.ReplicaCheckInterval <= 0 {
+			errs = append(errs, errors.New("database.replicaCheckInterval must be positive"))
+		}
+	}
+
+	switch c.ListenNetwork {
+	case "unix", "tcp":
+	default:
+		errs = append(errs, fmt.Errorf("listenNetwork must be unix or tcp, got %q", c.ListenNetwork))
+	}
+	if c.ListenAddress == "" {
+		errs = append(errs, errors.New("listenAddress must not be empty"))
+	}
+
+	// Listings are capped at 1000 records, so a page can't be bigger than that
+	if c.PageSize < 1 || c.PageSize > 1000 {
+		errs = append(errs, fmt.Errorf("pageSize must be between 1 and 1000, got %d", c.PageSize))
+	}
+	if time.Duration(c.UpdateInterval) < time.Minute {
+		errs = append(errs, fmt.Errorf("updateInterval must be at least 1m, got %s", time.Duration(c.UpdateInterval)))
+	}
+	if c.UpdateConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("updateConcurrency must be at least 1, got %d", c.UpdateConcurrency))
+	} else if c.Database.MaxOpenConns > 0 && c.UpdateConcurrency >= c.Database.MaxOpenConns {
+		// Leave a connection for the API while every worker is rebuilding
+		errs = append(errs, fmt.Errorf("updateConcurrency must be lower than database.maxOpenConns (%d), got %d", c.Database.MaxOpenConns, c.UpdateConcurrency))
+	}
+
+	if c.TimeTrialPenalty < 0 || time.Duration(c.TimeTrialPenalty)%time.Second != 0 {
+		errs = append(errs, fmt.Errorf("timeTrialPenalty must be a whole number of seconds and not negative, got %s", time.Duration(c.TimeTrialPenalty)))
+	}
+
+	if c.CacheTtl <= 0 {
+		errs = append(errs, errors.New("cacheTtl must be positive"))
+	}
+
+	if c.SnapshotRetention != 0 && time.Duration(c.SnapshotRetention) < 24*time.Hour {
+		errs = append(errs, fmt.Errorf("snapshotRetention must be 0 or at least 24h, got %s", time.Duration(c.SnapshotRetention)))
+	}
+
+	for window, interval := range c.WindowUpdateIntervals {
+		switch window {
+		case "day", "week", "month":
+		default:
+			errs = append(errs, fmt.Errorf("windowUpdateIntervals keys must be day, week or month, got %q", window))
+		}
+		if interval != 0 && time.Duration(interval) < time.Minute {
+			errs = append(errs, fmt.Errorf("windowUpdateIntervals.%s must be 0 or at least 1m, got %s", window, time.Duration(interval)))
+		}
+	}
+
+	switch c.Overall.Normalization {
+	case "rank", "value":
+	default:
+		errs = append(errs, fmt.Errorf("overall.normalization must be rank or value, got %q", c.Overall.Normalization))
+	}
+	for categoryId, weight := range c.Overall.Weights {
+		if weight < 0 {
+			errs = append(errs, fmt.Errorf("overall.weights.%s must not be negative", categoryId))
+		}
+	}
+
+	if c.Timeouts.Endpoint <= 0 {
+		errs = append(errs, errors.New("timeouts.endpoint must be positive"))
+	}
+	for endpoint, timeout := range c.Timeouts.Endpoints {
+		if timeout <= 0 {
+			errs = append(errs, fmt.Errorf("timeouts.endpoints.%s must be positive", endpoint))
+		}
+	}
+	if c.Timeouts.Category <= 0 {
+		errs = append(errs, errors.New("timeouts.category must be positive"))
+	}
+	for categoryId, timeout := range c.Timeouts.Categories {
+		if timeout <= 0 {
+			errs = append(errs, fmt.Errorf("timeouts.categories.%s must be positive", categoryId))
+		}
+	}
+
+	if len(c.Games) == 0 {
+		errs = append(errs, errors.New("games must list at least one game"))
+	}
+	seenGames := make(map[string]bool)
+	for _, game := range c.Games {
+		if game == "" {
+			errs = append(errs, errors.New("games must not contain an empty name"))
+		} else if seenGames[game] {
+			errs = append(errs, fmt.Errorf("games lists %q more than once", game))
+		}
+		seenGames[game] = true
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+func setString(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
+
+func splitList(value string) (list []string) {
+	for _, item := range strings.Split(value, ",") {
+		list = append(list, strings.TrimSpace(item))
+	}
+	return list
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		// wantErr is part of the expected error message, empty when the config is valid
+		wantErr string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"sqlite", func(c *Config) { c.Database.Driver = "sqlite" }, ""},
+		{"unknown driver", func(c *Config) { c.Database.Driver = "postgres" }, "database.driver"},
+		{"empty dsn", func(c *Config) { c.Database.Dsn = "" }, "database.dsn"},
+		{"replica with sqlite", func(c *Config) { c.Database.Driver = "sqlite"; c.Database.ReplicaDsn = "replica" }, "database.replicaDsn"},
+		{"unknown network", func(c *Config) { c.ListenNetwork = "udp" }, "listenNetwork"},
+		{"page size too big", func(c *Config) { c.PageSize = 1001 }, "pageSize"},
+		{"page size zero", func(c *Config) { c.PageSize = 0 }, "pageSize"},
+		{"update interval too short", func(c *Config) { c.UpdateInterval = Duration(30 * time.Second) }, "updateInterval"},
+		{"no workers", func(c *Config) { c.UpdateConcurrency = 0 }, "updateConcurrency"},
+		{"workers use every connection", func(c *Config) { c.Database.MaxOpenConns = 4; c.UpdateConcurrency = 4 }, "updateConcurrency"},
+		{"workers leave a connection", func(c *Config) { c.Database.MaxOpenConns = 5; c.UpdateConcurrency = 4 }, ""},
+		{"fractional penalty", func(c *Config) { c.TimeTrialPenalty = Duration(1500 * time.Millisecond) }, "timeTrialPenalty"},
+		{"negative penalty", func(c *Config) { c.TimeTrialPenalty = Duration(-time.Second) }, "timeTrialPenalty"},
+		{"zero cache ttl", func(c *Config) { c.CacheTtl = 0 }, "cacheTtl"},
+		{"short snapshot retention", func(c *Config) { c.SnapshotRetention = Duration(time.Hour) }, "snapshotRetention"},
+		{"snapshots disabled", func(c *Config) { c.SnapshotRetention = 0 }, ""},
+		{"unknown window", func(c *Config) { c.WindowUpdateIntervals["year"] = Duration(time.Hour) }, "windowUpdateIntervals"},
+		{"window disabled", func(c *Config) { c.WindowUpdateIntervals["day"] = 0 }, ""},
+		{"short window interval", func(c *Config) { c.WindowUpdateIntervals["day"] = Duration(time.Second) }, "windowUpdateIntervals.day"},
+		{"unknown normalization", func(c *Config) { c.Overall.Normalization = "score" }, "overall.normalization"},
+		{"negative weight", func(c *Config) { c.Overall.Weights = map[string]float64{"bp": -1} }, "overall.weights.bp"},
+		{"zero endpoint timeout", func(c *Config) { c.Timeouts.Endpoint = 0 }, "timeouts.endpoint"},
+		{"zero category timeout", func(c *Config) { c.Timeouts.Categories = map[string]Duration{"bp": 0} }, "timeouts.categories.bp"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := Default()
+			test.modify(&config)
+
+			err := config.Validate()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got error %v, want one about %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	config := Default()
+	config.PageSize = 0
+	config.CacheTtl = 0
+
+	err := config.Validate()
+	if err == nil || !strings.Contains(err.Error(), "pageSize") || !strings.Contains(err.Error(), "cacheTtl") {
+		t.Errorf("got error %v, want one about both pageSize and cacheTtl", err)
+	}
+}
+
+func TestReadFileKeepsOmittedWindowIntervals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"windowUpdateIntervals": {"day": "0s"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := Default()
+	if err := config.readFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]Duration{"day": 0, "week": Duration(time.Hour), "month": Duration(3 * time.Hour)}
+	for window, interval := range want {
+		if config.WindowUpdateIntervals[window] != interval {
+			t.Errorf("windowUpdateIntervals.%s: got %s, want %s", window, time.Duration(config.WindowUpdateIntervals[window]), time.Duration(interval))
+		}
+	}
+}
+
+func TestReadFileRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"pageSzie": 10}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := Default()
+	if err := config.readFile(path); err == nil {
+		t.Error("got no error for an unknown field")
+	}
+}
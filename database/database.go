@@ -1,40 +1,113 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/ynoproject/ynorankings/categories"
 	"github.com/ynoproject/ynorankings/common"
+	"github.com/ynoproject/ynorankings/config"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
-var Conn *sql.DB
+// Listings never go past this many records regardless of page size
+const MaxListedRecords = 1000
+
+// SqlStore is the RankingStore backed by the ynodb MySQL database, or a local SQLite copy of it.
+type SqlStore struct {
+	conn     *sql.DB
+	replica  *replica
+	dialect  dialect
+	pageSize int
+	// snapshotRetention is how long daily leaderboard snapshots are kept; none are taken when it is zero
+	snapshotRetention time.Duration
+}
 
-func Init() {
-	conn, err := sql.Open("mysql", "yno@unix(/run/mysqld/mysqld.sock)/ynodb?parseTime=true")
+func Init(cfg config.Config) RankingStore {
+	var store *SqlStore
+	var err error
+	switch cfg.Database.Driver {
+	case "sqlite":
+		store, err = openSqlite(cfg.Database.Dsn)
+	default:
+		store, err = openMysql(cfg.Database.Dsn)
+	}
 	if err != nil {
 		log.Fatal(err)
-		return
+		return nil
 	}
 
-	Conn = conn
+	if cfg.Database.ReplicaDsn != "" {
+		store.replica, err = openReplica(cfg.Database.ReplicaDsn, time.Duration(cfg.Database.ReplicaMaxLag), time.Duration(cfg.Database.ReplicaCheckInterval))
+		if err != nil {
+			log.Fatal(err)
+			return nil
+		}
+		setPoolLimits(store.replica.conn, cfg.Database)
+	}
+
+	setPoolLimits(store.conn, cfg.Database)
+
+	store.pageSize = cfg.PageSize
+	store.snapshotRetention = time.Duration(cfg.SnapshotRetention)
+
+	return store
 }
 
-func GetPlayerUuidFromToken(token string) (uuid string) {
-	err := Conn.QueryRow("SELECT a.uuid FROM accounts a JOIN playerSessions ps ON ps.uuid = a.uuid JOIN players pd ON pd.uuid = a.uuid WHERE ps.sessionId = ? AND NOW() < ps.expiration", token).Scan(&uuid)
+func setPoolLimits(conn *sql.DB, cfg config.DatabaseConfig) {
+	conn.SetMaxOpenConns(cfg.MaxOpenConns)
+	if cfg.MaxIdleConns > 0 {
+		conn.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	conn.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
+}
+
+func openMysql(dsn string) (*SqlStore, error) {
+	conn, err := sql.Open("mysql", dsn)
 	if err != nil {
-		return ""
+		return nil, err
 	}
 
-	return uuid
+	return &SqlStore{conn: conn, dialect: mysqlDialect{}}, nil
 }
 
-func GetEventPeriodData(gameName string) (eventPeriods []*common.EventPeriod, err error) {
-	results, err := Conn.Query("SELECT ep.periodOrdinal, ep.endDate, gep.enableVms FROM eventPeriods ep JOIN gameEventPeriods gep ON gep.periodId = ep.id AND gep.game = ? WHERE ep.periodOrdinal > 0", gameName)
+func (s *SqlStore) maxPages() int {
+	return MaxListedRecords / s.pageSize
+}
+
+func (s *SqlStore) GetPlayerUuidFromToken(ctx context.Context, token string) (uuid string, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	err = s.read(ctx, func(conn *sql.DB) error {
+		uuid, err = s.queryPlayerUuidFromToken(ctx, conn, token)
+		return err
+	})
+
+	return uuid, err
+}
+
+func (s *SqlStore) queryPlayerUuidFromToken(ctx context.Context, conn *sql.DB, token string) (uuid string, err error) {
+	err = conn.QueryRowContext(ctx, "SELECT a.uuid FROM accounts a JOIN playerSessions ps ON ps.uuid = a.uuid JOIN players pd ON pd.uuid = a.uuid WHERE ps.sessionId = ? AND NOW() < ps.expiration", token).Scan(&uuid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return uuid, nil
+}
+
+func (s *SqlStore) GetEventPeriodData(ctx context.Context, gameName string) (eventPeriods []*common.EventPeriod, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	results, err := s.conn.QueryContext(ctx, "SELECT ep.periodOrdinal, ep.endDate, gep.enableVms FROM eventPeriods ep JOIN gameEventPeriods gep ON gep.periodId = ep.id AND gep.game = ? WHERE ep.periodOrdinal > 0", gameName)
 	if err != nil {
 		return eventPeriods, err
 	}
@@ -55,8 +128,10 @@ func GetEventPeriodData(gameName string) (eventPeriods []*common.EventPeriod, er
 	return eventPeriods, nil
 }
 
-func GetCurrentEventPeriodOrdinal() (periodOrdinal int, err error) {
-	err = Conn.QueryRow("SELECT periodOrdinal FROM eventPeriods WHERE UTC_DATE() >= startDate AND UTC_DATE() < endDate").Scan(&periodOrdinal)
+func (s *SqlStore) GetCurrentEventPeriodOrdinal(ctx context.Context) (periodOrdinal int, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	err = s.conn.QueryRowContext(ctx, "SELECT periodOrdinal FROM eventPeriods WHERE UTC_DATE() >= startDate AND UTC_DATE() < endDate").Scan(&periodOrdinal)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return 0, nil
@@ -67,8 +142,10 @@ func GetCurrentEventPeriodOrdinal() (periodOrdinal int, err error) {
 	return periodOrdinal, nil
 }
 
-func GetTimeTrialMapIds() (mapIds []int, err error) {
-	results, err := Conn.Query("SELECT mapId FROM playerTimeTrials GROUP BY mapId ORDER BY MIN(seconds)")
+func (s *SqlStore) GetTimeTrialMapIds(ctx context.Context) (mapIds []int, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	results, err := s.conn.QueryContext(ctx, "SELECT mapId FROM playerTimeTrials GROUP BY mapId ORDER BY MIN(seconds)")
 	if err != nil {
 		return mapIds, err
 	}
@@ -88,8 +165,10 @@ func GetTimeTrialMapIds() (mapIds []int, err error) {
 	return mapIds, nil
 }
 
-func GetGameMinigameIds(gameName string) (minigameIds []string, err error) {
-	results, err := Conn.Query("SELECT DISTINCT minigameId FROM playerMinigameScores WHERE game = ? ORDER BY minigameId", gameName)
+func (s *SqlStore) GetGameMinigameIds(ctx context.Context, gameName string) (minigameIds []string, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	results, err := s.conn.QueryContext(ctx, "SELECT DISTINCT minigameId FROM playerMinigameScores WHERE game = ? ORDER BY minigameId", gameName)
 	if err != nil {
 		return minigameIds, err
 	}
@@ -109,8 +188,99 @@ func GetGameMinigameIds(gameName string) (minigameIds []string, err error) {
 	return minigameIds, nil
 }
 
-func GetRankingCategories(gameName string) (rankingCategories []*common.RankingCategory, err error) {
-	results, err := Conn.Query("SELECT categoryId, game FROM rankingCategories WHERE game IN ('', ?) ORDER BY ordinal", gameName)
+// sourceWatermark summarizes a table that leaderboards are computed from, aliased as t, such as by its row
+// count and latest timestamp, with values that change whenever its rows do. Tables tied to games can be
+// summarized for one game, through gameJoin when the game is on another table.
+type sourceWatermark struct {
+	columns    string
+	gameJoin   string
+	gameColumn string
+}
+
+const eventCompletionGameJoin = " LEFT JOIN eventLocations el ON el.id = t.eventId AND t.type = 0 LEFT JOIN playerEventLocations pel ON pel.id = t.eventId AND t.type = 1 LEFT JOIN eventVms ev ON ev.id = t.eventId AND t.type = 2 JOIN gameEventPeriods gep ON gep.id = COALESCE(el.gamePeriodId, pel.gamePeriodId, ev.gamePeriodId)"
+
+var sourceWatermarks = map[string]sourceWatermark{
+	"playerBadges":         {"COUNT(*), MAX(t.timestampUnlocked)", " JOIN badges b ON b.badgeId = t.badgeId", "b.game"},
+	"badges":               {"COUNT(*), SUM(t.bp), SUM(t.hidden)", "", "t.game"},
+	"eventCompletions":     {"COUNT(*), MAX(t.timestampCompleted), SUM(t.exp)", eventCompletionGameJoin, "gep.game"},
+	"eventLocations":       {"COUNT(*), MAX(t.id)", " JOIN gameEventPeriods gep ON gep.id = t.gamePeriodId", "gep.game"},
+	"playerEventLocations": {"COUNT(*), MAX(t.id)", " JOIN gameEventPeriods gep ON gep.id = t.gamePeriodId", "gep.game"},
+	"eventVms":             {"COUNT(*), MAX(t.id)", " JOIN gameEventPeriods gep ON gep.id = t.gamePeriodId", "gep.game"},
+	"gameLocations":        {"COUNT(*), SUM(t.secret)", "", ""},
+	"gameEventPeriods":     {"COUNT(*), MAX(t.id)", "", "t.game"},
+	"eventPeriods":         {"COUNT(*), MAX(t.startDate), MAX(t.endDate)", " JOIN gameEventPeriods gep ON gep.periodId = t.id", "gep.game"},
+	"playerTimeTrials":     {"COUNT(*), MAX(t.timestampCompleted)", "", ""},
+	"playerMinigameScores": {"COUNT(*), MAX(t.timestampCompleted)", "", "t.game"},
+}
+
+// GetSourceWatermark summarizes a source table, only counting the rows of gameName unless it's empty or the
+// table isn't tied to games.
+func (s *SqlStore) GetSourceWatermark(ctx context.Context, source string, gameName string) (watermark string, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	sourceWatermark, ok := sourceWatermarks[source]
+	if !ok {
+		return watermark, fmt.Errorf("unknown source %q", source)
+	}
+
+	query := "SELECT " + sourceWatermark.columns + " FROM " + source + " t"
+	var args []any
+	if gameName != "" && sourceWatermark.gameColumn != "" {
+		query += sourceWatermark.gameJoin + " WHERE " + sourceWatermark.gameColumn + " = ?"
+		args = append(args, gameName)
+	}
+
+	results, err := s.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return watermark, err
+	}
+
+	defer results.Close()
+
+	columnTypes, err := results.ColumnTypes()
+	if err != nil {
+		return watermark, err
+	}
+
+	values := make([]any, len(columnTypes))
+	valuePointers := make([]any, len(values))
+	for i := range values {
+		valuePointers[i] = &values[i]
+	}
+
+	if results.Next() {
+		err = results.Scan(valuePointers...)
+		if err != nil {
+			return watermark, err
+		}
+	}
+
+	for i, value := range values {
+		if i > 0 {
+			watermark += ","
+		}
+		if bytes, ok := value.([]byte); ok {
+			value = string(bytes)
+		}
+		watermark += fmt.Sprint(value)
+	}
+
+	return watermark, results.Err()
+}
+
+func (s *SqlStore) GetRankingCategories(ctx context.Context, gameName string) (rankingCategories []*common.RankingCategory, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	err = s.read(ctx, func(conn *sql.DB) error {
+		rankingCategories, err = s.queryRankingCategories(ctx, conn, gameName)
+		return err
+	})
+
+	return rankingCategories, err
+}
+
+func (s *SqlStore) queryRankingCategories(ctx context.Context, conn *sql.DB, gameName string) (rankingCategories []*common.RankingCategory, err error) {
+	results, err := conn.QueryContext(ctx, "SELECT categoryId, game FROM rankingCategories WHERE game IN ('', ?) ORDER BY ordinal", gameName)
 	if err != nil {
 		return rankingCategories, err
 	}
@@ -128,7 +298,7 @@ func GetRankingCategories(gameName string) (rankingCategories []*common.RankingC
 		rankingCategories = append(rankingCategories, rankingCategory)
 	}
 
-	results, err = Conn.Query("SELECT sc.categoryId, sc.subCategoryId, sc.game, CEILING(COUNT(r.uuid) / 25) FROM rankingSubCategories sc JOIN rankingEntries r ON r.categoryId = sc.categoryId AND r.subCategoryId = sc.subCategoryId WHERE sc.game IN ('', ?) AND sc.active GROUP BY sc.categoryId, sc.subCategoryId, sc.game ORDER BY 1, sc.ordinal", gameName)
+	results, err = conn.QueryContext(ctx, "SELECT sc.categoryId, sc.subCategoryId, sc.game, sc.timeWindow, CEILING(COUNT(r.uuid) / ?) FROM rankingSubCategories sc JOIN rankingEntries r ON r.categoryId = sc.categoryId AND r.subCategoryId = sc.subCategoryId WHERE sc.game IN ('', ?) AND sc.active GROUP BY sc.categoryId, sc.subCategoryId, sc.game, sc.timeWindow ORDER BY 1, sc.ordinal", float64(s.pageSize), gameName)
 	if err != nil {
 		return rankingCategories, err
 	}
@@ -142,24 +312,28 @@ func GetRankingCategories(gameName string) (rankingCategories []*common.RankingC
 		rankingSubCategory := &common.RankingSubCategory{}
 
 		var categoryId string
-		err := results.Scan(&categoryId, &rankingSubCategory.SubCategoryId, &rankingSubCategory.Game, &rankingSubCategory.PageCount)
+		err := results.Scan(&categoryId, &rankingSubCategory.SubCategoryId, &rankingSubCategory.Game, &rankingSubCategory.Window, &rankingSubCategory.PageCount)
 		if err != nil {
 			return rankingCategories, err
 		}
 
-		// Maximum 40 pages of 25 (1000 records)
-		if rankingSubCategory.PageCount > 40 {
-			rankingSubCategory.PageCount = 40
+		if rankingSubCategory.PageCount > s.maxPages() {
+			rankingSubCategory.PageCount = s.maxPages()
 		}
 
 		if lastCategoryId != categoryId {
 			lastCategoryId = categoryId
+			lastCategory = nil
 			for _, rankingCategory := range rankingCategories {
 				if rankingCategory.CategoryId == lastCategoryId {
 					lastCategory = rankingCategory
 				}
 			}
 		}
+		// Subcategories of categories not listed for the game are left out
+		if lastCategory == nil {
+			continue
+		}
 
 		lastCategory.SubCategories = append(lastCategory.SubCategories, *rankingSubCategory)
 	}
@@ -167,8 +341,10 @@ func GetRankingCategories(gameName string) (rankingCategories []*common.RankingC
 	return rankingCategories, nil
 }
 
-func WriteRankingCategory(categoryId string, game string, order int) (err error) {
-	_, err = Conn.Exec("INSERT INTO rankingCategories (categoryId, game, ordinal) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE ordinal = ?", categoryId, game, order, order)
+func (s *SqlStore) WriteRankingCategory(ctx context.Context, categoryId string, game string, order int, periodic bool) (err error) {
+	defer wrapTimeout(ctx, &err)
+
+	_, err = s.conn.ExecContext(ctx, "INSERT INTO rankingCategories (categoryId, game, ordinal, periodic) VALUES (?, ?, ?, ?) "+s.dialect.onConflictUpdate("categoryId", "game")+" ordinal = ?, periodic = ?", categoryId, game, order, periodic, order, periodic)
 	if err != nil {
 		return err
 	}
@@ -176,8 +352,10 @@ func WriteRankingCategory(categoryId string, game string, order int) (err error)
 	return nil
 }
 
-func WriteRankingSubCategory(categoryId string, subCategoryId string, game string, order int) (err error) {
-	_, err = Conn.Exec("INSERT INTO rankingSubCategories (categoryId, subCategoryId, game, ordinal) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE ordinal = ?", categoryId, subCategoryId, game, order, order)
+func (s *SqlStore) WriteRankingSubCategory(ctx context.Context, categoryId string, subCategoryId string, game string, window string, order int) (err error) {
+	defer wrapTimeout(ctx, &err)
+
+	_, err = s.conn.ExecContext(ctx, "INSERT INTO rankingSubCategories (categoryId, subCategoryId, game, timeWindow, ordinal) VALUES (?, ?, ?, ?, ?) "+s.dialect.onConflictUpdate("categoryId", "subCategoryId")+" ordinal = ?", categoryId, subCategoryId, game, window, order, order)
 	if err != nil {
 		return err
 	}
@@ -185,8 +363,19 @@ func WriteRankingSubCategory(categoryId string, subCategoryId string, game strin
 	return nil
 }
 
-func GetRankingEntryPage(playerUuid string, categoryId string, subCategoryId string) (page int, err error) {
-	err = Conn.QueryRow("SELECT FLOOR(r.rowNum / 25) + 1 FROM (SELECT r.uuid, ROW_NUMBER() OVER (ORDER BY r.position) rowNum FROM rankingEntries r WHERE r.categoryId = ? AND r.subCategoryId = ? AND r.actualPosition <= 1000) r WHERE r.uuid = ?", categoryId, subCategoryId, playerUuid).Scan(&page)
+func (s *SqlStore) GetRankingEntryPage(ctx context.Context, playerUuid string, categoryId string, subCategoryId string) (page int, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	err = s.read(ctx, func(conn *sql.DB) error {
+		page, err = s.queryRankingEntryPage(ctx, conn, playerUuid, categoryId, subCategoryId)
+		return err
+	})
+
+	return page, err
+}
+
+func (s *SqlStore) queryRankingEntryPage(ctx context.Context, conn *sql.DB, playerUuid string, categoryId string, subCategoryId string) (page int, err error) {
+	err = conn.QueryRowContext(ctx, "SELECT FLOOR((r.rowNum - 1) / ?) + 1 FROM (SELECT r.uuid, ROW_NUMBER() OVER (ORDER BY r.actualPosition, r.uuid) rowNum FROM rankingEntries r WHERE r.categoryId = ? AND r.subCategoryId = ? AND r.actualPosition <= ?) r WHERE r.uuid = ?", s.pageSize, categoryId, subCategoryId, MaxListedRecords, playerUuid).Scan(&page)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return 1, nil
@@ -194,23 +383,86 @@ func GetRankingEntryPage(playerUuid string, categoryId string, subCategoryId str
 		return 1, err
 	}
 
-	if page > 40 {
+	if page > s.maxPages() {
 		page = 1
 	}
 
 	return page, nil
 }
 
-func GetRankingsPaged(gameName string, categoryId string, subCategoryId string, page int) (rankings []*common.Ranking, err error) {
-	var valueType string
-	switch categoryId {
-	case "eventLocationCompletion":
-		valueType = "Float"
-	default:
-		valueType = "Int"
+func (s *SqlStore) GetRankingStanding(ctx context.Context, playerUuid string, categoryId string, subCategoryId string) (standing *common.RankingStanding, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	err = s.read(ctx, func(conn *sql.DB) error {
+		standing, err = s.queryRankingStanding(ctx, conn, playerUuid, categoryId, subCategoryId)
+		return err
+	})
+
+	return standing, err
+}
+
+func (s *SqlStore) queryRankingStanding(ctx context.Context, conn *sql.DB, playerUuid string, categoryId string, subCategoryId string) (standing *common.RankingStanding, err error) {
+	standing = &common.RankingStanding{}
+
+	// Players tied with them share their position, so the percentile counts everyone placed at or above it
+	var actualPosition, placedAbove int
+	err = conn.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(MAX(p.position), 0), COALESCE(MAX(p.actualPosition), 0), COALESCE(SUM(CASE WHEN r.position <= p.position THEN 1 ELSE 0 END), 0) FROM rankingEntries r LEFT JOIN rankingEntries p ON p.categoryId = r.categoryId AND p.subCategoryId = r.subCategoryId AND p.uuid = ? WHERE r.categoryId = ? AND r.subCategoryId = ?", playerUuid, categoryId, subCategoryId).Scan(&standing.Total, &standing.Position, &actualPosition, &placedAbove)
+	if err != nil {
+		return standing, err
+	}
+
+	if standing.Position == 0 {
+		return standing, nil
+	}
+
+	standing.Percentile = float32(placedAbove) * 100 / float32(standing.Total)
+	standing.Tier = standingTier(actualPosition, standing.Percentile)
+
+	return standing, nil
+}
+
+// standingTier uses the same position brackets as the medals awarded by UpdatePlayerMedals.
+func standingTier(actualPosition int, percentile float32) string {
+	switch {
+	case actualPosition == 1:
+		return "diamond"
+	case actualPosition <= 3:
+		return "platinum"
+	case actualPosition <= 10:
+		return "gold"
+	case actualPosition <= 30:
+		return "silver"
+	case actualPosition <= 100:
+		return "bronze"
 	}
 
-	results, err := Conn.Query("SELECT r.position, a.user, pd.rank, a.badge, COALESCE(pgd.systemName, ''), COALESCE(pgd.medalCountBronze, 0), COALESCE(pgd.medalCountSilver, 0), COALESCE(pgd.medalCountGold, 0), COALESCE(pgd.medalCountPlatinum, 0), COALESCE(pgd.medalCountDiamond, 0), r.value"+valueType+" FROM rankingEntries r JOIN accounts a ON a.uuid = r.uuid JOIN players pd ON pd.uuid = a.uuid LEFT JOIN playerGameData pgd ON pgd.uuid = pd.uuid AND pgd.game = ? WHERE r.categoryId = ? AND r.subCategoryId = ? ORDER BY CASE WHEN r.actualPosition > 0 THEN r.actualPosition ELSE r.position END LIMIT "+strconv.Itoa((page-1)*25)+", 25", gameName, categoryId, subCategoryId)
+	for _, bracket := range []int{1, 5, 10, 25, 50} {
+		if percentile <= float32(bracket) {
+			return "top" + strconv.Itoa(bracket)
+		}
+	}
+
+	return ""
+}
+
+func (s *SqlStore) GetRankingsPaged(ctx context.Context, gameName string, categoryId string, subCategoryId string, page int) (rankings []*common.Ranking, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	err = s.read(ctx, func(conn *sql.DB) error {
+		rankings, err = s.queryRankingsPaged(ctx, conn, gameName, categoryId, subCategoryId, page)
+		return err
+	})
+
+	return rankings, err
+}
+
+func (s *SqlStore) queryRankingsPaged(ctx context.Context, conn *sql.DB, gameName string, categoryId string, subCategoryId string, page int) (rankings []*common.Ranking, err error) {
+	valueType := categories.ValueInt
+	if definition, ok := categories.Get(categoryId); ok {
+		valueType = definition.ValueType()
+	}
+
+	results, err := conn.QueryContext(ctx, "SELECT r.position, a.user, pd.rank, a.badge, COALESCE(pgd.systemName, ''), COALESCE(pgd.medalCountBronze, 0), COALESCE(pgd.medalCountSilver, 0), COALESCE(pgd.medalCountGold, 0), COALESCE(pgd.medalCountPlatinum, 0), COALESCE(pgd.medalCountDiamond, 0), r.value"+string(valueType)+", r.previousPosition, r.dayPosition, r.weekPosition, r.isNew FROM rankingEntries r JOIN accounts a ON a.uuid = r.uuid JOIN players pd ON pd.uuid = a.uuid LEFT JOIN playerGameData pgd ON pgd.uuid = pd.uuid AND pgd.game = ? WHERE r.categoryId = ? AND r.subCategoryId = ? ORDER BY r.actualPosition, r.uuid LIMIT "+strconv.Itoa((page-1)*s.pageSize)+", "+strconv.Itoa(s.pageSize), gameName, categoryId, subCategoryId)
 	if err != nil {
 		return rankings, err
 	}
@@ -220,177 +472,414 @@ func GetRankingsPaged(gameName string, categoryId string, subCategoryId string,
 	for results.Next() {
 		ranking := &common.Ranking{}
 
-		if valueType == "Int" {
-			err = results.Scan(&ranking.Position, &ranking.Name, &ranking.Rank, &ranking.Badge, &ranking.SystemName, &ranking.Medals[0], &ranking.Medals[1], &ranking.Medals[2], &ranking.Medals[3], &ranking.Medals[4], &ranking.ValueInt)
+		var dayPosition, weekPosition int
+		if valueType == categories.ValueInt {
+			err = results.Scan(&ranking.Position, &ranking.Name, &ranking.Rank, &ranking.Badge, &ranking.SystemName, &ranking.Medals[0], &ranking.Medals[1], &ranking.Medals[2], &ranking.Medals[3], &ranking.Medals[4], &ranking.ValueInt, &ranking.PreviousPosition, &dayPosition, &weekPosition, &ranking.New)
 		} else {
-			err = results.Scan(&ranking.Position, &ranking.Name, &ranking.Rank, &ranking.Badge, &ranking.SystemName, &ranking.Medals[0], &ranking.Medals[1], &ranking.Medals[2], &ranking.Medals[3], &ranking.Medals[4], &ranking.ValueFloat)
+			err = results.Scan(&ranking.Position, &ranking.Name, &ranking.Rank, &ranking.Badge, &ranking.SystemName, &ranking.Medals[0], &ranking.Medals[1], &ranking.Medals[2], &ranking.Medals[3], &ranking.Medals[4], &ranking.ValueFloat, &ranking.PreviousPosition, &dayPosition, &weekPosition, &ranking.New)
 		}
 		if err != nil {
 			return rankings, err
 		}
 
+		ranking.PositionChange = positionChange(ranking.PreviousPosition, ranking.Position)
+		ranking.DayPositionChange = positionChange(dayPosition, ranking.Position)
+		ranking.WeekPositionChange = positionChange(weekPosition, ranking.Position)
+
 		rankings = append(rankings, ranking)
 	}
 
 	return rankings, nil
 }
 
-func UpdateRankingEntries(categoryId string, subCategoryId string, gameId string) (err error) {
-	var valueType string
-	switch categoryId {
-	case "eventLocationCompletion":
-		valueType = "Float"
-	default:
-		valueType = "Int"
+// GetBadgeFirstUnlockers lists the players who were among the first to unlock a badge, straight from their unlocks.
+func (s *SqlStore) GetBadgeFirstUnlockers(ctx context.Context, badgeId string) (unlockers []*common.BadgeUnlocker, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	err = s.read(ctx, func(conn *sql.DB) error {
+		unlockers, err = s.queryBadgeFirstUnlockers(ctx, conn, badgeId)
+		return err
+	})
+
+	return unlockers, err
+}
+
+func (s *SqlStore) queryBadgeFirstUnlockers(ctx context.Context, conn *sql.DB, badgeId string) (unlockers []*common.BadgeUnlocker, err error) {
+	results, err := conn.QueryContext(ctx, "SELECT u.place, a.user, pd.rank, a.badge, COALESCE(pgd.systemName, ''), COALESCE(pgd.medalCountBronze, 0), COALESCE(pgd.medalCountSilver, 0), COALESCE(pgd.medalCountGold, 0), COALESCE(pgd.medalCountPlatinum, 0), COALESCE(pgd.medalCountDiamond, 0), u.timestampUnlocked FROM (SELECT pb.uuid, pb.badgeId, pb.timestampUnlocked, RANK() OVER (ORDER BY pb.timestampUnlocked) place FROM playerBadges pb WHERE pb.badgeId = ?) u JOIN badges b ON b.badgeId = u.badgeId JOIN accounts a ON a.uuid = u.uuid JOIN players pd ON pd.uuid = a.uuid LEFT JOIN playerGameData pgd ON pgd.uuid = pd.uuid AND pgd.game = b.game WHERE u.place <= ? ORDER BY u.place, u.uuid", badgeId, categories.FirstUnlockPlaces)
+	if err != nil {
+		return unlockers, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		unlocker := &common.BadgeUnlocker{}
+
+		var timestamp nullableTime
+		err = results.Scan(&unlocker.Position, &unlocker.Name, &unlocker.Rank, &unlocker.Badge, &unlocker.SystemName, &unlocker.Medals[0], &unlocker.Medals[1], &unlocker.Medals[2], &unlocker.Medals[3], &unlocker.Medals[4], &timestamp)
+		if err != nil {
+			return unlockers, err
+		}
+		unlocker.Timestamp = timestamp.Time
+
+		unlockers = append(unlockers, unlocker)
+	}
+
+	return unlockers, nil
+}
+
+// positionChange is how many places a player moved up since they were at the previous position.
+func positionChange(previousPosition int, position int) int {
+	if previousPosition == 0 {
+		return 0
+	}
+	return previousPosition - position
+}
+
+func (s *SqlStore) UpdateRankingEntries(ctx context.Context, categoryId string, subCategoryId string, gameId string) (err error) {
+	defer wrapTimeout(ctx, &err)
+
+	return s.rebuildRankingEntries(ctx, categoryId, subCategoryId, gameId, false)
+}
+
+// FinalizeRankingEntries rebuilds a leaderboard one last time and locks it, so UpdateRankingEntries leaves it
+// alone from then on. Finalizing it again recomputes it from the current data.
+func (s *SqlStore) FinalizeRankingEntries(ctx context.Context, categoryId string, subCategoryId string, gameId string) (err error) {
+	defer wrapTimeout(ctx, &err)
+
+	return s.rebuildRankingEntries(ctx, categoryId, subCategoryId, gameId, true)
+}
+
+// LockRankingEntries finalizes a leaderboard as it stands without rebuilding it. Leaderboards that have no
+// entries yet aren't locked, so they can be finalized with FinalizeRankingEntries instead.
+func (s *SqlStore) LockRankingEntries(ctx context.Context, categoryId string, subCategoryId string) (locked bool, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	result, err := s.conn.ExecContext(ctx, "INSERT INTO rankingFinalizations (categoryId, subCategoryId, finalizedAt) SELECT e.categoryId, e.subCategoryId, ? FROM rankingEntries e WHERE e.categoryId = ? AND e.subCategoryId = ? LIMIT 1", time.Now().UTC(), categoryId, subCategoryId)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (s *SqlStore) IsRankingFinalized(ctx context.Context, categoryId string, subCategoryId string) (finalized bool, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	return isRankingFinalized(ctx, s.conn, categoryId, subCategoryId)
+}
+
+// rowQuerier is either the connection or a rebuild transaction.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func isRankingFinalized(ctx context.Context, conn rowQuerier, categoryId string, subCategoryId string) (finalized bool, err error) {
+	err = conn.QueryRowContext(ctx, "SELECT 1 FROM rankingFinalizations WHERE categoryId = ? AND subCategoryId = ?", categoryId, subCategoryId).Scan(&finalized)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	return finalized, err
+}
+
+func (s *SqlStore) rebuildRankingEntries(ctx context.Context, categoryId string, subCategoryId string, gameId string, finalize bool) (err error) {
+	definition, ok := categories.Get(categoryId)
+	if !ok {
+		return fmt.Errorf("unknown category %s", categoryId)
+	}
+
+	valueType := definition.ValueType()
+
+	baseSubCategoryId, window := categories.SplitWindow(subCategoryId)
+	var since time.Time
+	if window != "" {
+		since = window.Start(time.Now())
+	}
+
+	sourceQuery, queryArgs := definition.SourceQuery(baseSubCategoryId, gameId, since)
+
+	tieBreak := definition.TieBreak()
+
+	columns := "s.uuid, s.value, s.timestamp"
+	order := "s.value " + string(definition.SortDirection())
+	if tieBreak == categories.TieBreakSecondary {
+		columns += ", s.secondary"
+		order += ", s.secondary DESC"
+	}
+	if tieBreak == categories.TieBreakShared || tieBreak == categories.TieBreakUuid {
+		order += ", s.uuid"
+	} else {
+		order += ", s.timestamp, s.uuid"
 	}
 
-	_, err = Conn.Exec("DELETE FROM rankingEntries WHERE categoryId = ? AND subCategoryId = ?", categoryId, subCategoryId)
+	// Players without a positive value are left off the leaderboard
+	query := "SELECT " + columns + " FROM (" + sourceQuery + ") s WHERE s.value > 0 ORDER BY " + order
+
+	results, err := s.conn.QueryContext(ctx, query, queryArgs...)
 	if err != nil {
 		return err
 	}
 
-	var queryArgs []any
+	defer results.Close()
 
-	queryArgs = append(queryArgs, categoryId, subCategoryId)
+	var entries []*common.RankingEntry
+	var secondaries []float64
 
-	isFiltered := subCategoryId != "all"
+	for results.Next() {
+		entry := &common.RankingEntry{CategoryId: categoryId, SubCategoryId: subCategoryId}
+		var timestamp nullableTime
+		var secondary float64
 
-	var query string
-	switch categoryId {
-	case "badgeCount":
-		query = "SELECT ?, ?, RANK() OVER (ORDER BY COUNT(pb.uuid) DESC), 0, a.uuid, COUNT(pb.uuid), (SELECT MAX(apb.timestampUnlocked) FROM playerBadges apb JOIN badges ab ON ab.badgeId = apb.badgeId WHERE apb.uuid = a.uuid AND ab.game = b.game) FROM playerBadges pb JOIN accounts a ON a.uuid = pb.uuid JOIN badges b ON b.badgeId = pb.badgeId WHERE b.hidden = 0"
-		if isFiltered {
-			query += " AND b.game = ?"
+		scanArgs := []any{&entry.Uuid, &entry.ValueInt, &timestamp}
+		if valueType == categories.ValueFloat {
+			scanArgs[1] = &entry.ValueFloat
 		}
-		query += " GROUP BY a.uuid"
-	case "bp":
-		query = "SELECT ?, ?, RANK() OVER (ORDER BY SUM(b.bp) DESC), 0, a.uuid, SUM(b.bp), (SELECT MAX(apb.timestampUnlocked) FROM playerBadges apb JOIN badges ab ON ab.badgeId = apb.badgeId WHERE apb.uuid = a.uuid AND ab.game = b.game) FROM playerBadges pb JOIN accounts a ON a.uuid = pb.uuid JOIN badges b ON b.badgeId = pb.badgeId"
-		if isFiltered {
-			query += " WHERE b.game = ?"
+		if tieBreak == categories.TieBreakSecondary {
+			scanArgs = append(scanArgs, &secondary)
 		}
-		query += " GROUP BY a.uuid"
-	case "exp":
-		query = "SELECT ?, ?, RANK() OVER (ORDER BY SUM(ec.exp) DESC), 0, ec.uuid, SUM(ec.exp), (SELECT MAX(aec.timestampCompleted) FROM eventCompletions aec WHERE aec.uuid = ec.uuid AND aec.exp > 0) FROM ((SELECT ec.uuid, ec.exp FROM eventCompletions ec JOIN eventLocations el ON el.id = ec.eventId AND ec.type = 0"
-		if isFiltered {
-			query += " JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId AND ep.periodOrdinal = ?"
+		err = results.Scan(scanArgs...)
+		if err != nil {
+			return err
 		}
-		query += ") UNION ALL (SELECT ec.uuid, ec.exp FROM eventCompletions ec JOIN eventVms ev ON ev.id = ec.eventId AND ec.type = 2"
-		if isFiltered {
-			query += " JOIN gameEventPeriods gep ON gep.id = ev.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId AND ep.periodOrdinal = ?"
-			queryArgs = append(queryArgs, subCategoryId)
+		entry.Timestamp = timestamp.Time
+
+		entries = append(entries, entry)
+		secondaries = append(secondaries, secondary)
+	}
+
+	if err := results.Err(); err != nil {
+		return err
+	}
+
+	assignPositions(entries, secondaries, tieBreak)
+
+	return s.replaceRankingEntries(ctx, categoryId, subCategoryId, valueType, entries, finalize)
+}
+
+// assignPositions numbers entries already sorted in tie-break order. Players tied on value and secondary metric
+// are shown at the same position, and also share their actual position with TieBreakShared; TieBreakUuid gives
+// everyone their own position.
+func assignPositions(entries []*common.RankingEntry, secondaries []float64, tieBreak categories.TieBreak) {
+	for i, entry := range entries {
+		entry.Position = i + 1
+		entry.ActualPosition = entry.Position
+		if i == 0 || tieBreak == categories.TieBreakUuid {
+			continue
 		}
-		query += ")) ec GROUP BY ec.uuid"
-	case "eventLocationCount", "freeEventLocationCount_" + gameId:
-		isEventLocationCount := categoryId == "eventLocationCount"
-		query = "SELECT ?, ?, RANK() OVER (ORDER BY COUNT(ec.uuid) DESC), 0, ec.uuid, COUNT(ec.uuid), (SELECT MAX(aec.timestampCompleted) FROM eventCompletions aec WHERE aec.uuid = ec.uuid AND aec.type = "
-		if isEventLocationCount {
-			query += "0"
-		} else {
-			query += "1"
-		}
-		query += ") FROM eventCompletions ec "
-		if isFiltered {
-			if isEventLocationCount {
-				query += "JOIN eventLocations el"
-			} else {
-				query += "JOIN playerEventLocations el"
-			}
-			query += " ON el.id = ec.eventId JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId "
-			if categoryId != "eventLocationCount" {
-				query += "AND gep.game = ? "
-				queryArgs = append(queryArgs, gameId)
+
+		previous := entries[i-1]
+		if previous.ValueInt == entry.ValueInt && previous.ValueFloat == entry.ValueFloat && secondaries[i-1] == secondaries[i] {
+			entry.Position = previous.Position
+			if tieBreak == categories.TieBreakShared {
+				entry.ActualPosition = previous.ActualPosition
 			}
-			query += "JOIN eventPeriods ep ON ep.id = gep.periodId AND ep.periodOrdinal = ? "
 		}
-		query += "WHERE ec.type = "
-		if isEventLocationCount {
-			query += "0"
-		} else {
-			query += "1"
+	}
+}
+
+// replaceRankingEntries swaps in the new leaderboard within a single transaction, so readers keep seeing the
+// previous one until the commit and an error at any point leaves it untouched. Finalized leaderboards are only
+// replaced when finalizing them again.
+func (s *SqlStore) replaceRankingEntries(ctx context.Context, categoryId string, subCategoryId string, valueType categories.ValueType, entries []*common.RankingEntry, finalize bool) (err error) {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if finalize {
+		_, err = tx.ExecContext(ctx, "INSERT INTO rankingFinalizations (categoryId, subCategoryId, finalizedAt) VALUES (?, ?, ?) "+s.dialect.onConflictUpdate("categoryId", "subCategoryId")+" finalizedAt = ?", categoryId, subCategoryId, time.Now().UTC(), time.Now().UTC())
+		if err != nil {
+			return err
 		}
-		query += " GROUP BY ec.uuid"
-	case "eventLocationCompletion":
-		query = "SELECT ?, ?, RANK() OVER ( ORDER BY COUNT( DISTINCT COALESCE(el.locationId, pel.locationId) ) / aec.count DESC ), 0, a.uuid, COUNT( DISTINCT COALESCE(el.locationId, pel.locationId) ) / aec.count, aect.maxTimestamp FROM eventCompletions ec JOIN accounts a ON a.uuid = ec.uuid LEFT JOIN eventLocations el ON el.id = ec.eventId AND ec.type = 0 LEFT JOIN playerEventLocations pel ON pel.id = ec.eventId AND ec.type = 1 LEFT JOIN ( SELECT gl.id, gl.secret FROM gameLocations gl ) gl ON COALESCE(el.locationId, pel.locationId) = gl.id JOIN ( SELECT COUNT( DISTINCT COALESCE(ael.locationId, apel.locationId) ) count FROM eventCompletions aec LEFT JOIN eventLocations ael ON ael.id = aec.eventId AND aec.type = 0 LEFT JOIN playerEventLocations apel ON apel.id = aec.eventId AND aec.type = 1 LEFT JOIN ( SELECT agl.id, agl.secret FROM gameLocations agl ) agl ON COALESCE(ael.locationId, apel.locationId) = agl.id JOIN gameEventPeriods agep ON agep.id = COALESCE( ael.gamePeriodId, apel.gamePeriodId ) AND agep.game = ? WHERE ( ael.locationId IS NOT NULL OR apel.locationId IS NOT NULL ) AND agl.secret = 0 ) aec JOIN ( SELECT aect.uuid, MAX(aect.timestampCompleted) maxTimestamp FROM eventCompletions aect GROUP BY aect.uuid ) aect ON aect.uuid = ec.uuid JOIN gameEventPeriods gep ON gep.id = COALESCE( el.gamePeriodId, pel.gamePeriodId ) AND gep.game = ? JOIN eventPeriods ep ON ep.id = gep.periodId"
-		queryArgs = append(queryArgs, gameId, gameId)
-		if isFiltered {
-			query += " AND ep.periodOrdinal = ?"
+	} else {
+		finalized, err := isRankingFinalized(ctx, tx, categoryId, subCategoryId)
+		if err != nil {
+			return err
 		}
-		query += " WHERE gl.secret = 0 GROUP BY a.user"
-	case "eventVmCount":
-		query = "SELECT ?, ?, RANK() OVER (ORDER BY COUNT(ec.uuid) DESC), 0, ec.uuid, COUNT(ec.uuid), MAX(aec.timestampCompleted) FROM eventCompletions ec JOIN (SELECT aec.eventId, aec.uuid, aec.timestampCompleted FROM eventCompletions aec WHERE aec.type = 2) aec ON aec.uuid = ec.uuid AND aec.eventId = ec.eventId "
-		if isFiltered {
-			query += "JOIN eventVms ev ON ev.id = ec.eventId JOIN gameEventPeriods gep ON gep.id = ev.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId AND ep.periodOrdinal = ? "
+		if finalized {
+			return fmt.Errorf("%w: %s/%s", ErrFinalized, categoryId, subCategoryId)
 		}
-		query += "WHERE ec.type = 2 GROUP BY ec.uuid"
-	case "timeTrial":
-		query = "SELECT ?, ?, RANK() OVER (ORDER BY MIN(tt.seconds)), 0, tt.uuid, MIN(tt.seconds), (SELECT MAX(att.timestampCompleted) FROM playerTimeTrials att WHERE att.uuid = tt.uuid AND att.mapId = tt.mapId AND att.seconds = tt.seconds) FROM playerTimeTrials tt WHERE tt.mapId = ? GROUP BY tt.uuid"
-	case "minigame":
-		query = "SELECT ?, ?, RANK() OVER (ORDER BY MAX(ms.score) DESC), 0, ms.uuid, MAX(ms.score), (SELECT MAX(ams.timestampCompleted) FROM playerMinigameScores ams WHERE ams.uuid = ms.uuid AND ams.minigameId = ms.minigameId AND ams.score = ms.score) FROM playerMinigameScores ms WHERE ms.minigameId = ? GROUP BY ms.uuid"
 	}
 
-	query += " ORDER BY 3, 7"
+	err = s.setPreviousPositions(ctx, tx, categoryId, subCategoryId, entries)
+	if err != nil {
+		return err
+	}
 
-	if isFiltered {
-		queryArgs = append(queryArgs, subCategoryId)
+	_, err = tx.ExecContext(ctx, "DELETE FROM rankingEntries WHERE categoryId = ? AND subCategoryId = ?", categoryId, subCategoryId)
+	if err != nil {
+		return err
 	}
 
-	results, err := Conn.Query(query, queryArgs...)
+	err = insertRankingEntries(ctx, tx, valueType, entries, "")
 	if err != nil {
 		return err
 	}
 
+	if s.snapshotRetention > 0 {
+		err = s.snapshotRankingEntries(ctx, tx, categoryId, subCategoryId, valueType, entries)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// setPreviousPositions fills in where each player stood on the leaderboard being replaced, and 24h and 7d ago
+// going by the snapshots. Players are only new if there was a previous leaderboard they weren't on.
+func (s *SqlStore) setPreviousPositions(ctx context.Context, tx *sql.Tx, categoryId string, subCategoryId string, entries []*common.RankingEntry) (err error) {
+	previousPositions, err := queryPositions(ctx, tx, "SELECT uuid, position FROM rankingEntries WHERE categoryId = ? AND subCategoryId = ?", categoryId, subCategoryId)
+	if err != nil {
+		return err
+	}
+
+	var dayPositions, weekPositions map[string]int
+	if s.snapshotRetention > 0 {
+		snapshotQuery := "SELECT uuid, position FROM rankingSnapshots WHERE categoryId = ? AND subCategoryId = ? AND snapshotDate = (SELECT MAX(snapshotDate) FROM rankingSnapshots WHERE categoryId = ? AND subCategoryId = ? AND snapshotDate <= ?)"
+		now := time.Now().UTC()
+
+		dayPositions, err = queryPositions(ctx, tx, snapshotQuery, categoryId, subCategoryId, categoryId, subCategoryId, now.AddDate(0, 0, -1).Format(time.DateOnly))
+		if err != nil {
+			return err
+		}
+
+		weekPositions, err = queryPositions(ctx, tx, snapshotQuery, categoryId, subCategoryId, categoryId, subCategoryId, now.AddDate(0, 0, -7).Format(time.DateOnly))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		entry.PreviousPosition = previousPositions[entry.Uuid]
+		entry.DayPosition = dayPositions[entry.Uuid]
+		entry.WeekPosition = weekPositions[entry.Uuid]
+		entry.New = len(previousPositions) > 0 && entry.PreviousPosition == 0
+	}
+
+	return nil
+}
+
+func queryPositions(ctx context.Context, tx *sql.Tx, query string, args ...any) (positions map[string]int, err error) {
+	results, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return positions, err
+	}
+
 	defer results.Close()
 
+	positions = make(map[string]int)
+
+	for results.Next() {
+		var uuid string
+		var position int
+		err = results.Scan(&uuid, &position)
+		if err != nil {
+			return positions, err
+		}
+
+		positions[uuid] = position
+	}
+
+	return positions, results.Err()
+}
+
+// snapshotRankingEntries keeps the listed part of the new leaderboard as the snapshot for the current day,
+// replacing one taken by an earlier rebuild that day.
+func (s *SqlStore) snapshotRankingEntries(ctx context.Context, tx *sql.Tx, categoryId string, subCategoryId string, valueType categories.ValueType, entries []*common.RankingEntry) (err error) {
+	snapshotDate := time.Now().UTC().Format(time.DateOnly)
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM rankingSnapshots WHERE categoryId = ? AND subCategoryId = ? AND snapshotDate = ?", categoryId, subCategoryId, snapshotDate)
+	if err != nil {
+		return err
+	}
+
+	var listedEntries []*common.RankingEntry
+	for _, entry := range entries {
+		if entry.ActualPosition <= MaxListedRecords {
+			listedEntries = append(listedEntries, entry)
+		}
+	}
+
+	return insertRankingEntries(ctx, tx, valueType, listedEntries, snapshotDate)
+}
+
+// insertRankingEntries writes entries in batches to rankingEntries, or to rankingSnapshots when a snapshot
+// date is given.
+func insertRankingEntries(ctx context.Context, tx *sql.Tx, valueType categories.ValueType, entries []*common.RankingEntry, snapshotDate string) (err error) {
+	insertQuery := "INSERT INTO rankingEntries (categoryId, subCategoryId, position, actualPosition, uuid, value" + string(valueType) + ", timestamp, previousPosition, dayPosition, weekPosition, isNew) VALUES "
+	placeholder := "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	if snapshotDate != "" {
+		insertQuery = "INSERT INTO rankingSnapshots (snapshotDate, categoryId, subCategoryId, position, actualPosition, uuid, value" + string(valueType) + ", timestamp) VALUES "
+		placeholder = "(?, ?, ?, ?, ?, ?, ?, ?)"
+	}
+
 	var placeholders []string
 	var entryValues []any
 
-	var batchRowIndex int
-	for results.Next() {
-		entry := &common.RankingEntry{}
-		if valueType == "Float" {
-			results.Scan(&entry.CategoryId, &entry.SubCategoryId, &entry.Position, &entry.ActualPosition, &entry.Uuid, &entry.ValueFloat, &entry.Timestamp)
-			if entry.ValueFloat <= 0 {
-				break
-			}
-		} else {
-			results.Scan(&entry.CategoryId, &entry.SubCategoryId, &entry.Position, &entry.ActualPosition, &entry.Uuid, &entry.ValueInt, &entry.Timestamp)
-			if entry.ValueInt <= 0 {
-				break
-			}
+	for i, entry := range entries {
+		placeholders = append(placeholders, placeholder)
+		if snapshotDate != "" {
+			entryValues = append(entryValues, snapshotDate)
 		}
-		placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?, ?)")
 		entryValues = append(entryValues, entry.CategoryId, entry.SubCategoryId, entry.Position, entry.ActualPosition, entry.Uuid)
-		if valueType == "Float" {
+		if valueType == categories.ValueFloat {
 			entryValues = append(entryValues, entry.ValueFloat)
 		} else {
 			entryValues = append(entryValues, entry.ValueInt)
 		}
 		entryValues = append(entryValues, entry.Timestamp)
+		if snapshotDate == "" {
+			entryValues = append(entryValues, entry.PreviousPosition, entry.DayPosition, entry.WeekPosition, entry.New)
+		}
 
-		batchRowIndex++
-
-		if batchRowIndex == 1000 {
-			err = WriteRankingEntries(valueType, placeholders, entryValues)
+		if len(placeholders) == 1000 || i == len(entries)-1 {
+			_, err = tx.ExecContext(ctx, insertQuery+strings.Join(placeholders, ","), entryValues...)
 			if err != nil {
 				return err
 			}
 
-			batchRowIndex = 0
 			placeholders = placeholders[:0]
 			entryValues = entryValues[:0]
 		}
 	}
 
-	if len(entryValues) == 0 {
-		return nil
-	}
+	return nil
+}
 
-	err = WriteRankingEntries(valueType, placeholders, entryValues)
-	if err != nil {
-		return err
+func (s *SqlStore) UpdatePlayerMedals(ctx context.Context, gameName string) (err error) {
+	defer wrapTimeout(ctx, &err)
+
+	// Composite leaderboards and aggregate subcategories only restate the others, so placing on them isn't
+	// worth another medal
+	excludedCondition := ""
+	args := []any{gameName, gameName, common.CurrentEventPeriodOrdinal.Load()}
+	for _, definition := range categories.All() {
+		categoryId := definition.Id()
+		if definition.SeparateByGame() {
+			categoryId += "_" + gameName
+		}
+		if definition.Composite() {
+			excludedCondition += " AND e.categoryId <> ?"
+			args = append(args, categoryId)
+			continue
+		}
+		for _, subCategoryId := range definition.AggregateSubCategories(gameName) {
+			excludedCondition += " AND NOT (e.categoryId = ? AND e.subCategoryId = ?)"
+			args = append(args, categoryId, subCategoryId)
+		}
 	}
+	args = append(args, gameName)
 
-	_, err = Conn.Exec("UPDATE rankingEntries e JOIN (WITH re AS (SELECT e.categoryId, e.subCategoryId, e.position, e.timestamp, ROW_NUMBER() OVER (ORDER BY e.position, e.timestamp) actualPosition FROM rankingEntries e WHERE e.categoryId = ? AND e.subCategoryId = ?) SELECT * FROM re) re ON re.categoryId = e.categoryId AND re.subCategoryId = e.subCategoryId AND re.position = e.position AND re.timestamp = e.timestamp SET e.actualPosition = re.actualPosition", categoryId, subCategoryId)
+	_, err = s.conn.ExecContext(ctx, s.dialect.updateJoin("playerGameData AS pgd", "(SELECT uuid, SUM(CASE WHEN actualPosition <= 100 AND actualPosition > 30 THEN 1 ELSE 0 END) bronze, SUM(CASE WHEN actualPosition <= 30 AND actualPosition > 10 THEN 1 ELSE 0 END) silver, SUM(CASE WHEN actualPosition <= 10 AND actualPosition > 1 THEN 1 ELSE 0 END) gold, SUM(CASE WHEN actualPosition <= 3 AND actualPosition > 1 THEN 1 ELSE 0 END) plat, SUM(CASE WHEN actualPosition = 1 THEN 1 ELSE 0 END) diamond FROM rankingEntries e JOIN rankingCategories rc ON rc.categoryId = e.categoryId JOIN rankingSubCategories rsc ON rsc.categoryId = e.categoryId AND rsc.subCategoryId = e.subCategoryId AND rc.game IN ('', ?) AND rsc.game IN ('', ?) AND rsc.active AND rsc.timeWindow = '' WHERE (rc.periodic = 0 OR e.subCategoryId IN ('all', ?))"+excludedCondition+" GROUP BY uuid) m", "m.uuid = pgd.uuid", "medalCountBronze = m.bronze, medalCountSilver = m.silver, medalCountGold = m.gold, medalCountPlatinum = m.plat, medalCountDiamond = m.diamond", "pgd.game = ?"), args...)
 	if err != nil {
 		return err
 	}
@@ -398,18 +887,55 @@ func UpdateRankingEntries(categoryId string, subCategoryId string, gameId string
 	return nil
 }
 
-func WriteRankingEntries(valueType string, placeholders []string, entryValues []any) (err error) {
-	insertQuery := fmt.Sprintf("INSERT INTO rankingEntries (categoryId, subCategoryId, position, actualPosition, uuid, value"+valueType+", timestamp) VALUES %s", strings.Join(placeholders, ","))
-	_, err = Conn.Exec(insertQuery, entryValues...)
-	if err != nil {
+// GetRankingsPagedAt lists a page of a leaderboard as of the latest snapshot taken on or before the given time.
+// Player names, ranks and medals are the current ones.
+func (s *SqlStore) GetRankingsPagedAt(ctx context.Context, gameName string, categoryId string, subCategoryId string, page int, at time.Time) (rankings []*common.Ranking, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	err = s.read(ctx, func(conn *sql.DB) error {
+		rankings, err = s.queryRankingsPagedAt(ctx, conn, gameName, categoryId, subCategoryId, page, at)
 		return err
+	})
+
+	return rankings, err
+}
+
+func (s *SqlStore) queryRankingsPagedAt(ctx context.Context, conn *sql.DB, gameName string, categoryId string, subCategoryId string, page int, at time.Time) (rankings []*common.Ranking, err error) {
+	valueType := categories.ValueInt
+	if definition, ok := categories.Get(categoryId); ok {
+		valueType = definition.ValueType()
 	}
 
-	return nil
+	results, err := conn.QueryContext(ctx, "SELECT r.position, a.user, pd.rank, a.badge, COALESCE(pgd.systemName, ''), COALESCE(pgd.medalCountBronze, 0), COALESCE(pgd.medalCountSilver, 0), COALESCE(pgd.medalCountGold, 0), COALESCE(pgd.medalCountPlatinum, 0), COALESCE(pgd.medalCountDiamond, 0), r.value"+string(valueType)+" FROM rankingSnapshots r JOIN accounts a ON a.uuid = r.uuid JOIN players pd ON pd.uuid = a.uuid LEFT JOIN playerGameData pgd ON pgd.uuid = pd.uuid AND pgd.game = ? WHERE r.categoryId = ? AND r.subCategoryId = ? AND r.snapshotDate = (SELECT MAX(rs.snapshotDate) FROM rankingSnapshots rs WHERE rs.categoryId = ? AND rs.subCategoryId = ? AND rs.snapshotDate <= ?) ORDER BY r.actualPosition, r.uuid LIMIT "+strconv.Itoa((page-1)*s.pageSize)+", "+strconv.Itoa(s.pageSize), gameName, categoryId, subCategoryId, categoryId, subCategoryId, at.UTC().Format(time.DateOnly))
+	if err != nil {
+		return rankings, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		ranking := &common.Ranking{}
+
+		if valueType == categories.ValueInt {
+			err = results.Scan(&ranking.Position, &ranking.Name, &ranking.Rank, &ranking.Badge, &ranking.SystemName, &ranking.Medals[0], &ranking.Medals[1], &ranking.Medals[2], &ranking.Medals[3], &ranking.Medals[4], &ranking.ValueInt)
+		} else {
+			err = results.Scan(&ranking.Position, &ranking.Name, &ranking.Rank, &ranking.Badge, &ranking.SystemName, &ranking.Medals[0], &ranking.Medals[1], &ranking.Medals[2], &ranking.Medals[3], &ranking.Medals[4], &ranking.ValueFloat)
+		}
+		if err != nil {
+			return rankings, err
+		}
+
+		rankings = append(rankings, ranking)
+	}
+
+	return rankings, nil
 }
 
-func UpdatePlayerMedals(gameName string) (err error) {
-	_, err = Conn.Exec("UPDATE playerGameData pgd JOIN (SELECT uuid, SUM(CASE WHEN actualPosition <= 100 AND actualPosition > 30 THEN 1 ELSE 0 END) bronze, SUM(CASE WHEN actualPosition <= 30 AND actualPosition > 10 THEN 1 ELSE 0 END) silver, SUM(CASE WHEN actualPosition <= 10 AND actualPosition > 1 THEN 1 ELSE 0 END) gold, SUM(CASE WHEN actualPosition <= 3 AND actualPosition > 1 THEN 1 ELSE 0 END) plat, SUM(CASE WHEN actualPosition = 1 THEN 1 ELSE 0 END) diamond FROM rankingEntries e JOIN rankingCategories rc ON rc.categoryId = e.categoryId JOIN rankingSubCategories rsc ON rsc.categoryId = e.categoryId AND rsc.subCategoryId = e.subCategoryId AND rc.game IN ('', ?) AND rsc.game IN ('', ?) AND rsc.active WHERE (rc.periodic = 0 OR e.subCategoryId IN ('all', ?)) GROUP BY uuid) m ON m.uuid = pgd.uuid SET pgd.medalCountBronze = m.bronze, pgd.medalCountSilver = m.silver, pgd.medalCountGold = m.gold, pgd.medalCountPlatinum = m.plat, pgd.medalCountDiamond = m.diamond WHERE pgd.game = ?", gameName, gameName, common.CurrentEventPeriodOrdinal, gameName)
+// PruneRankingSnapshots deletes snapshots taken before the given time.
+func (s *SqlStore) PruneRankingSnapshots(ctx context.Context, before time.Time) (err error) {
+	defer wrapTimeout(ctx, &err)
+
+	_, err = s.conn.ExecContext(ctx, "DELETE FROM rankingSnapshots WHERE snapshotDate < ?", before.UTC().Format(time.DateOnly))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,41 @@
+package database
+
+import "strings"
+
+// dialect covers the few statements that MySQL and SQLite spell differently.
+type dialect interface {
+	// name is also the directory holding the dialect's migrations
+	name() string
+	// onConflictUpdate starts the upsert clause of an INSERT, to be followed by "column = ?" assignments
+	onConflictUpdate(keyColumns ...string) string
+	// updateJoin builds an UPDATE of target using the rows of source matched by on
+	updateJoin(target string, source string, on string, set string, where string) string
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) name() string {
+	return "mysql"
+}
+
+func (mysqlDialect) onConflictUpdate(keyColumns ...string) string {
+	return "ON DUPLICATE KEY UPDATE"
+}
+
+func (mysqlDialect) updateJoin(target string, source string, on string, set string, where string) string {
+	return "UPDATE " + target + " JOIN " + source + " ON " + on + " SET " + set + " WHERE " + where
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string {
+	return "sqlite"
+}
+
+func (sqliteDialect) onConflictUpdate(keyColumns ...string) string {
+	return "ON CONFLICT (" + strings.Join(keyColumns, ", ") + ") DO UPDATE SET"
+}
+
+func (sqliteDialect) updateJoin(target string, source string, on string, set string, where string) string {
+	return "UPDATE " + target + " SET " + set + " FROM " + source + " WHERE " + on + " AND " + where
+}
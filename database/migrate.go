@@ -0,0 +1,243 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+type schemaMigration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// getMigrations reads the embedded migrations for the store's dialect, ordered by version.
+// Files are named <version>_<name>.up.sql and <version>_<name>.down.sql.
+func (s *SqlStore) getMigrations() (migrations []*schemaMigration, err error) {
+	dir := path.Join("migrations", s.dialect.name())
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return migrations, err
+	}
+
+	migrationsByVersion := make(map[int]*schemaMigration)
+
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		var direction string
+		var baseName string
+		if strings.HasSuffix(fileName, ".up.sql") {
+			direction = "up"
+			baseName = strings.TrimSuffix(fileName, ".up.sql")
+		} else if strings.HasSuffix(fileName, ".down.sql") {
+			direction = "down"
+			baseName = strings.TrimSuffix(fileName, ".down.sql")
+		} else {
+			continue
+		}
+
+		versionStr, name, _ := strings.Cut(baseName, "_")
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return migrations, fmt.Errorf("invalid migration file name %s", fileName)
+		}
+
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, fileName))
+		if err != nil {
+			return migrations, err
+		}
+
+		migration, ok := migrationsByVersion[version]
+		if !ok {
+			migration = &schemaMigration{Version: version, Name: name}
+			migrationsByVersion[version] = migration
+			migrations = append(migrations, migration)
+		}
+
+		if direction == "up" {
+			migration.up = string(content)
+		} else {
+			migration.down = string(content)
+		}
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func (s *SqlStore) getAppliedMigrations(ctx context.Context) (appliedAt map[int]time.Time, err error) {
+	_, err = s.conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schemaMigrations (version INT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, appliedAt DATETIME NOT NULL)")
+	if err != nil {
+		return appliedAt, err
+	}
+
+	results, err := s.conn.QueryContext(ctx, "SELECT version, appliedAt FROM schemaMigrations")
+	if err != nil {
+		return appliedAt, err
+	}
+
+	defer results.Close()
+
+	appliedAt = make(map[int]time.Time)
+
+	for results.Next() {
+		var version int
+		var timestamp nullableTime
+		err := results.Scan(&version, &timestamp)
+		if err != nil {
+			return appliedAt, err
+		}
+
+		appliedAt[version] = timestamp.Time
+	}
+
+	return appliedAt, nil
+}
+
+func (s *SqlStore) GetMigrationStatus(ctx context.Context) (statuses []*MigrationStatus, err error) {
+	defer wrapTimeout(ctx, &err)
+
+	migrations, err := s.getMigrations()
+	if err != nil {
+		return statuses, err
+	}
+
+	appliedAt, err := s.getAppliedMigrations(ctx)
+	if err != nil {
+		return statuses, err
+	}
+
+	for _, migration := range migrations {
+		timestamp, applied := appliedAt[migration.Version]
+		statuses = append(statuses, &MigrationStatus{Version: migration.Version, Name: migration.Name, Applied: applied, AppliedAt: timestamp})
+	}
+
+	return statuses, nil
+}
+
+// MigrateUp applies every pending migration in order.
+func (s *SqlStore) MigrateUp(ctx context.Context) (err error) {
+	defer wrapTimeout(ctx, &err)
+
+	migrations, err := s.getMigrations()
+	if err != nil {
+		return err
+	}
+
+	appliedAt, err := s.getAppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if _, applied := appliedAt[migration.Version]; applied {
+			continue
+		}
+
+		err = s.execMigration(ctx, migration.up)
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+
+		_, err = s.conn.ExecContext(ctx, "INSERT INTO schemaMigrations (version, name, appliedAt) VALUES (?, ?, ?)", migration.Version, migration.Name, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateDown reverts the most recently applied migration.
+func (s *SqlStore) MigrateDown(ctx context.Context) (err error) {
+	defer wrapTimeout(ctx, &err)
+
+	migrations, err := s.getMigrations()
+	if err != nil {
+		return err
+	}
+
+	appliedAt, err := s.getAppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if _, applied := appliedAt[migration.Version]; !applied {
+			continue
+		}
+
+		err = s.execMigration(ctx, migration.down)
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+
+		_, err = s.conn.ExecContext(ctx, "DELETE FROM schemaMigrations WHERE version = ?", migration.Version)
+		return err
+	}
+
+	return nil
+}
+
+// CheckSchemaVersion fails when an embedded migration has not been applied yet.
+func (s *SqlStore) CheckSchemaVersion(ctx context.Context) (err error) {
+	defer wrapTimeout(ctx, &err)
+
+	statuses, err := s.GetMigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, fmt.Sprintf("%04d_%s", status.Version, status.Name))
+		}
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind, pending migrations: %s (run `ynorankings migrate up`)", strings.Join(pending, ", "))
+	}
+
+	return nil
+}
+
+// execMigration runs each statement of a migration file separately, as the MySQL driver
+// only accepts one statement per Exec without multiStatements.
+func (s *SqlStore) execMigration(ctx context.Context, script string) (err error) {
+	for _, statement := range strings.Split(script, ";\n") {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+
+		_, err = s.conn.ExecContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
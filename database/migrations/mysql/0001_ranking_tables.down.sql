@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS rankingEntries;
+DROP TABLE IF EXISTS rankingSubCategories;
+DROP TABLE IF EXISTS rankingCategories;
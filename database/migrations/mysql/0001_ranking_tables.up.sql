@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS rankingCategories (
+	categoryId VARCHAR(64) NOT NULL,
+	game VARCHAR(32) NOT NULL DEFAULT '',
+	ordinal INT NOT NULL DEFAULT 0,
+	periodic TINYINT(1) NOT NULL DEFAULT 0,
+	PRIMARY KEY (categoryId, game)
+);
+
+CREATE TABLE IF NOT EXISTS rankingSubCategories (
+	categoryId VARCHAR(64) NOT NULL,
+	subCategoryId VARCHAR(64) NOT NULL,
+	game VARCHAR(32) NOT NULL DEFAULT '',
+	ordinal INT NOT NULL DEFAULT 0,
+	active TINYINT(1) NOT NULL DEFAULT 1,
+	PRIMARY KEY (categoryId, subCategoryId)
+);
+
+CREATE TABLE IF NOT EXISTS rankingEntries (
+	categoryId VARCHAR(64) NOT NULL,
+	subCategoryId VARCHAR(64) NOT NULL,
+	position INT NOT NULL,
+	actualPosition INT NOT NULL DEFAULT 0,
+	uuid VARCHAR(16) NOT NULL,
+	valueInt INT NOT NULL DEFAULT 0,
+	valueFloat FLOAT NOT NULL DEFAULT 0,
+	timestamp DATETIME NULL,
+	PRIMARY KEY (categoryId, subCategoryId, uuid),
+	INDEX rankingEntries_actualPosition (categoryId, subCategoryId, actualPosition)
+);
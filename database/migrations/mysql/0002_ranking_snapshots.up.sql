@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS rankingSnapshots (
+	categoryId VARCHAR(64) NOT NULL,
+	subCategoryId VARCHAR(64) NOT NULL,
+	snapshotDate DATE NOT NULL,
+	position INT NOT NULL,
+	actualPosition INT NOT NULL DEFAULT 0,
+	uuid VARCHAR(16) NOT NULL,
+	valueInt INT NOT NULL DEFAULT 0,
+	valueFloat FLOAT NOT NULL DEFAULT 0,
+	timestamp DATETIME NULL,
+	PRIMARY KEY (categoryId, subCategoryId, snapshotDate, uuid),
+	INDEX rankingSnapshots_snapshotDate (snapshotDate)
+);
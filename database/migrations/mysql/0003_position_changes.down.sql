@@ -0,0 +1,5 @@
+ALTER TABLE rankingEntries
+	DROP COLUMN previousPosition,
+	DROP COLUMN dayPosition,
+	DROP COLUMN weekPosition,
+	DROP COLUMN isNew;
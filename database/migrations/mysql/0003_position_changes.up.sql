@@ -0,0 +1,5 @@
+ALTER TABLE rankingEntries
+	ADD COLUMN previousPosition INT NOT NULL DEFAULT 0,
+	ADD COLUMN dayPosition INT NOT NULL DEFAULT 0,
+	ADD COLUMN weekPosition INT NOT NULL DEFAULT 0,
+	ADD COLUMN isNew TINYINT(1) NOT NULL DEFAULT 0;
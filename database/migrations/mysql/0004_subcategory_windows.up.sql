@@ -0,0 +1 @@
+ALTER TABLE rankingSubCategories ADD COLUMN timeWindow VARCHAR(8) NOT NULL DEFAULT '';
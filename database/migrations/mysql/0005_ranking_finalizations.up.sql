@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS rankingFinalizations (
+	categoryId VARCHAR(64) NOT NULL,
+	subCategoryId VARCHAR(64) NOT NULL,
+	finalizedAt DATETIME NOT NULL,
+	PRIMARY KEY (categoryId, subCategoryId)
+);
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS rankingCategories (
+	categoryId TEXT NOT NULL,
+	game TEXT NOT NULL DEFAULT '',
+	ordinal INTEGER NOT NULL DEFAULT 0,
+	periodic INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (categoryId, game)
+);
+
+CREATE TABLE IF NOT EXISTS rankingSubCategories (
+	categoryId TEXT NOT NULL,
+	subCategoryId TEXT NOT NULL,
+	game TEXT NOT NULL DEFAULT '',
+	ordinal INTEGER NOT NULL DEFAULT 0,
+	active INTEGER NOT NULL DEFAULT 1,
+	PRIMARY KEY (categoryId, subCategoryId)
+);
+
+CREATE TABLE IF NOT EXISTS rankingEntries (
+	categoryId TEXT NOT NULL,
+	subCategoryId TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	actualPosition INTEGER NOT NULL DEFAULT 0,
+	uuid TEXT NOT NULL,
+	valueInt INTEGER NOT NULL DEFAULT 0,
+	valueFloat REAL NOT NULL DEFAULT 0,
+	timestamp DATETIME,
+	PRIMARY KEY (categoryId, subCategoryId, uuid)
+);
+
+CREATE INDEX IF NOT EXISTS rankingEntries_actualPosition ON rankingEntries (categoryId, subCategoryId, actualPosition);
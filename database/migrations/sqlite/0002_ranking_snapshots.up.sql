@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS rankingSnapshots (
+	categoryId TEXT NOT NULL,
+	subCategoryId TEXT NOT NULL,
+	snapshotDate TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	actualPosition INTEGER NOT NULL DEFAULT 0,
+	uuid TEXT NOT NULL,
+	valueInt INTEGER NOT NULL DEFAULT 0,
+	valueFloat REAL NOT NULL DEFAULT 0,
+	timestamp DATETIME,
+	PRIMARY KEY (categoryId, subCategoryId, snapshotDate, uuid)
+);
+
+CREATE INDEX IF NOT EXISTS rankingSnapshots_snapshotDate ON rankingSnapshots (snapshotDate);
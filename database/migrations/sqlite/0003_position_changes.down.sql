@@ -0,0 +1,4 @@
+ALTER TABLE rankingEntries DROP COLUMN previousPosition;
+ALTER TABLE rankingEntries DROP COLUMN dayPosition;
+ALTER TABLE rankingEntries DROP COLUMN weekPosition;
+ALTER TABLE rankingEntries DROP COLUMN isNew;
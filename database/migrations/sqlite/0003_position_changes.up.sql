@@ -0,0 +1,4 @@
+ALTER TABLE rankingEntries ADD COLUMN previousPosition INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE rankingEntries ADD COLUMN dayPosition INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE rankingEntries ADD COLUMN weekPosition INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE rankingEntries ADD COLUMN isNew INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE rankingSubCategories DROP COLUMN timeWindow;
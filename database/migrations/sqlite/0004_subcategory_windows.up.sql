@@ -0,0 +1 @@
+ALTER TABLE rankingSubCategories ADD COLUMN timeWindow TEXT NOT NULL DEFAULT '';
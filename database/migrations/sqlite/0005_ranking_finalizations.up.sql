@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS rankingFinalizations (
+	categoryId TEXT NOT NULL,
+	subCategoryId TEXT NOT NULL,
+	finalizedAt DATETIME NOT NULL,
+	PRIMARY KEY (categoryId, subCategoryId)
+);
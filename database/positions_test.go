@@ -0,0 +1,95 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/ynoproject/ynorankings/categories"
+	"github.com/ynoproject/ynorankings/common"
+)
+
+func TestAssignPositions(t *testing.T) {
+	tests := []struct {
+		name        string
+		tieBreak    categories.TieBreak
+		values      []int
+		secondaries []float64
+		// positions and actual positions expected for each entry, in order
+		positions       []int
+		actualPositions []int
+	}{
+		{
+			name:            "no ties",
+			tieBreak:        categories.TieBreakEarliest,
+			values:          []int{30, 20, 10},
+			positions:       []int{1, 2, 3},
+			actualPositions: []int{1, 2, 3},
+		},
+		{
+			name:            "earliest shows ties together but keeps actual positions",
+			tieBreak:        categories.TieBreakEarliest,
+			values:          []int{30, 20, 20, 10},
+			positions:       []int{1, 2, 2, 4},
+			actualPositions: []int{1, 2, 3, 4},
+		},
+		{
+			name:            "shared ties share actual positions",
+			tieBreak:        categories.TieBreakShared,
+			values:          []int{30, 30, 30, 10},
+			positions:       []int{1, 1, 1, 4},
+			actualPositions: []int{1, 1, 1, 4},
+		},
+		{
+			name:            "secondary breaks value ties",
+			tieBreak:        categories.TieBreakSecondary,
+			values:          []int{20, 20, 20, 10},
+			secondaries:     []float64{5, 3, 3, 9},
+			positions:       []int{1, 2, 2, 4},
+			actualPositions: []int{1, 2, 3, 4},
+		},
+		{
+			name:            "uuid never ties",
+			tieBreak:        categories.TieBreakUuid,
+			values:          []int{20, 20, 10, 10},
+			positions:       []int{1, 2, 3, 4},
+			actualPositions: []int{1, 2, 3, 4},
+		},
+		{
+			name:     "empty",
+			tieBreak: categories.TieBreakShared,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries := make([]*common.RankingEntry, len(test.values))
+			secondaries := make([]float64, len(test.values))
+			for i, value := range test.values {
+				entries[i] = &common.RankingEntry{ValueInt: value}
+				if test.secondaries != nil {
+					secondaries[i] = test.secondaries[i]
+				}
+			}
+
+			assignPositions(entries, secondaries, test.tieBreak)
+
+			for i, entry := range entries {
+				if entry.Position != test.positions[i] || entry.ActualPosition != test.actualPositions[i] {
+					t.Errorf("entry %d: got position %d, actual %d; want %d, %d", i, entry.Position, entry.ActualPosition, test.positions[i], test.actualPositions[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAssignPositionsFloatValues(t *testing.T) {
+	entries := []*common.RankingEntry{{ValueFloat: 0.5}, {ValueFloat: 0.5}, {ValueFloat: 0.25}}
+
+	assignPositions(entries, make([]float64, len(entries)), categories.TieBreakEarliest)
+
+	want := []int{1, 1, 3}
+	for i, entry := range entries {
+		if entry.Position != want[i] {
+			t.Errorf("entry %d: got position %d, want %d", i, entry.Position, want[i])
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// replica is an optional read-only pool for the API queries, used only while it is reachable and caught up.
+type replica struct {
+	conn    *sql.DB
+	maxLag  time.Duration
+	healthy atomic.Bool
+}
+
+func openReplica(dsn string, maxLag time.Duration, checkInterval time.Duration) (*replica, error) {
+	conn, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &replica{conn: conn, maxLag: maxLag}
+	r.check(checkInterval)
+
+	go func() {
+		for range time.Tick(checkInterval) {
+			r.check(checkInterval)
+		}
+	}()
+
+	return r, nil
+}
+
+func (r *replica) check(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	lag, err := getReplicationLag(ctx, r.conn)
+	if err == nil && lag > r.maxLag {
+		err = fmt.Errorf("replica is %s behind", lag)
+	}
+
+	if err != nil {
+		if r.healthy.Swap(false) {
+			log.Print("SERVER ", "replica ", "falling back to primary: ", err.Error())
+		}
+		return
+	}
+
+	if !r.healthy.Swap(true) {
+		log.Print("SERVER ", "replica ", "serving reads")
+	}
+}
+
+// getReplicationLag reads Seconds_Behind_Source (Seconds_Behind_Master before MySQL 8.0.22), which is NULL
+// whenever replication is stopped.
+func getReplicationLag(ctx context.Context, conn *sql.DB) (lag time.Duration, err error) {
+	results, err := conn.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		results, err = conn.QueryContext(ctx, "SHOW SLAVE STATUS")
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	defer results.Close()
+
+	columns, err := results.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	if !results.Next() {
+		if err := results.Err(); err != nil {
+			return 0, err
+		}
+		return 0, errors.New("server is not a replica")
+	}
+
+	values := make([]sql.RawBytes, len(columns))
+	scanArgs := make([]any, len(columns))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+
+	err = results.Scan(scanArgs...)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, column := range columns {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		if values[i] == nil {
+			return 0, errors.New("replication is not running")
+		}
+		seconds, err := strconv.Atoi(string(values[i]))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return 0, errors.New("replication lag is not reported")
+}
+
+// read runs a read-only query on the replica when it is usable and retries on the primary if the replica fails.
+// The query func must reset anything it accumulates, since it can be called twice.
+func (s *SqlStore) read(ctx context.Context, query func(conn *sql.DB) error) error {
+	if s.replica != nil && s.replica.healthy.Load() {
+		err := query(s.replica.conn)
+		if err == nil || err == sql.ErrNoRows || ctx.Err() != nil {
+			return err
+		}
+
+		log.Print("SERVER ", "replica ", "query failed, retrying on primary: ", err.Error())
+		s.replica.healthy.Store(false)
+	}
+
+	return query(s.conn)
+}
@@ -0,0 +1,106 @@
+-- Mirrors the ynodb tables owned by the game server that ynorankings reads from
+-- The ranking tables themselves are created by the migrations
+
+CREATE TABLE IF NOT EXISTS accounts (
+	uuid TEXT PRIMARY KEY,
+	user TEXT NOT NULL,
+	badge TEXT NOT NULL DEFAULT 'null'
+);
+
+CREATE TABLE IF NOT EXISTS players (
+	uuid TEXT PRIMARY KEY,
+	rank INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS playerSessions (
+	sessionId TEXT PRIMARY KEY,
+	uuid TEXT NOT NULL,
+	expiration DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS playerGameData (
+	uuid TEXT NOT NULL,
+	game TEXT NOT NULL,
+	systemName TEXT,
+	medalCountBronze INTEGER NOT NULL DEFAULT 0,
+	medalCountSilver INTEGER NOT NULL DEFAULT 0,
+	medalCountGold INTEGER NOT NULL DEFAULT 0,
+	medalCountPlatinum INTEGER NOT NULL DEFAULT 0,
+	medalCountDiamond INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (uuid, game)
+);
+
+CREATE TABLE IF NOT EXISTS badges (
+	badgeId TEXT PRIMARY KEY,
+	game TEXT NOT NULL,
+	bp INTEGER NOT NULL DEFAULT 0,
+	hidden INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS playerBadges (
+	uuid TEXT NOT NULL,
+	badgeId TEXT NOT NULL,
+	timestampUnlocked DATETIME NOT NULL,
+	PRIMARY KEY (uuid, badgeId)
+);
+
+CREATE TABLE IF NOT EXISTS eventPeriods (
+	id INTEGER PRIMARY KEY,
+	periodOrdinal INTEGER NOT NULL,
+	startDate DATE NOT NULL,
+	endDate DATE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS gameEventPeriods (
+	id INTEGER PRIMARY KEY,
+	game TEXT NOT NULL,
+	periodId INTEGER NOT NULL,
+	enableVms INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS gameLocations (
+	id INTEGER PRIMARY KEY,
+	secret INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS eventLocations (
+	id INTEGER PRIMARY KEY,
+	gamePeriodId INTEGER NOT NULL,
+	locationId INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS playerEventLocations (
+	id INTEGER PRIMARY KEY,
+	gamePeriodId INTEGER NOT NULL,
+	uuid TEXT NOT NULL,
+	locationId INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS eventVms (
+	id INTEGER PRIMARY KEY,
+	gamePeriodId INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS eventCompletions (
+	eventId INTEGER NOT NULL,
+	uuid TEXT NOT NULL,
+	type INTEGER NOT NULL,
+	exp INTEGER NOT NULL DEFAULT 0,
+	timestampCompleted DATETIME NOT NULL,
+	PRIMARY KEY (eventId, uuid, type)
+);
+
+CREATE TABLE IF NOT EXISTS playerTimeTrials (
+	uuid TEXT NOT NULL,
+	mapId INTEGER NOT NULL,
+	seconds INTEGER NOT NULL,
+	timestampCompleted DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS playerMinigameScores (
+	uuid TEXT NOT NULL,
+	game TEXT NOT NULL,
+	minigameId TEXT NOT NULL,
+	score INTEGER NOT NULL,
+	timestampCompleted DATETIME NOT NULL
+);
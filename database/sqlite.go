@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const sqliteDriverName = "sqlite3_ynorankings"
+
+var (
+	//go:embed schema/sqlite.sql
+	sqliteSchema string
+)
+
+func init() {
+	// Provide the MySQL functions used by the shared queries
+	sql.Register(sqliteDriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("NOW", func() string {
+				return time.Now().UTC().Format(time.DateTime)
+			}, false); err != nil {
+				return err
+			}
+			if err := conn.RegisterFunc("UTC_DATE", func() string {
+				return time.Now().UTC().Format(time.DateOnly)
+			}, false); err != nil {
+				return err
+			}
+			if err := conn.RegisterFunc("CEILING", func(value any) int64 {
+				return int64(math.Ceil(sqliteFloat(value)))
+			}, true); err != nil {
+				return err
+			}
+			return conn.RegisterFunc("FLOOR", func(value any) int64 {
+				return int64(math.Floor(sqliteFloat(value)))
+			}, true)
+		},
+	})
+}
+
+func sqliteFloat(value any) float64 {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// openSqlite opens (and creates if needed) a local SQLite database with the full ynodb schema.
+func openSqlite(path string) (*SqlStore, error) {
+	// Rebuilds read the old entries before writing, so their transactions take the write lock upfront to wait
+	// on concurrent rebuilds instead of failing to upgrade a read lock
+	conn, err := sql.Open(sqliteDriverName, "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Exec(sqliteSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SqlStore{conn: conn, dialect: sqliteDialect{}}, nil
+}
+
+// nullableTime scans timestamps that SQLite returns as text when they come from an expression rather than a column.
+type nullableTime struct {
+	time.Time
+}
+
+func (t *nullableTime) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v
+		return nil
+	case []byte:
+		return t.parse(string(v))
+	case string:
+		return t.parse(v)
+	}
+
+	return fmt.Errorf("unsupported timestamp type %T", value)
+}
+
+func (t *nullableTime) parse(value string) (err error) {
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		t.Time, err = time.ParseInLocation(format, value, time.UTC)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,435 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ynoproject/ynorankings/categories"
+	"github.com/ynoproject/ynorankings/common"
+)
+
+func TestMain(m *testing.M) {
+	common.GameNames = []string{"2kki", "yume"}
+	if err := categories.RegisterOverall(map[string]float64{"bp": 1, "badgeCount": 1, "exp": 1, "timeTrial": 1, "minigame": 1}, categories.NormalizeRank); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// testSourceRows is a small ynodb: 2kki has two event periods, the second one current, and yume has none. Only
+// carol has played a time trial today, on one of the two maps.
+const testSourceRows = `
+	INSERT INTO accounts (uuid, user) VALUES ('u1', 'alice'), ('u2', 'bob'), ('u3', 'carol');
+	INSERT INTO players (uuid, rank) VALUES ('u1', 1), ('u2', 0), ('u3', 0);
+	INSERT INTO playerGameData (uuid, game, systemName) VALUES ('u1', '2kki', 'sys'), ('u2', '2kki', ''), ('u3', 'yume', '');
+	INSERT INTO badges (badgeId, game, bp, hidden) VALUES ('b1', '2kki', 10, 0), ('b2', '2kki', 20, 0), ('b3', 'yume', 5, 0), ('b4', '2kki', 50, 1);
+	INSERT INTO playerBadges VALUES ('u1', 'b1', '2024-01-01 10:00:00'), ('u1', 'b2', '2024-01-02 10:00:00'), ('u2', 'b1', '2024-01-01 09:00:00'), ('u2', 'b2', '2024-01-01 11:00:00'), ('u3', 'b3', '2024-02-01 00:00:00');
+	INSERT INTO eventPeriods VALUES (1, 1, '2024-01-01', '2024-03-01'), (2, 2, '2024-03-01', '2099-01-01');
+	INSERT INTO gameEventPeriods VALUES (1, '2kki', 1, 1), (2, '2kki', 2, 1);
+	INSERT INTO gameLocations VALUES (1, 0), (2, 0), (3, 1);
+	INSERT INTO eventLocations VALUES (1, 1, 1), (2, 2, 2), (3, 2, 1);
+	INSERT INTO playerEventLocations VALUES (1, 2, 'u1', 2);
+	INSERT INTO eventVms VALUES (1, 2);
+	INSERT INTO eventCompletions VALUES (1, 'u1', 0, 3, '2024-01-05 00:00:00'), (2, 'u1', 0, 3, '2024-03-05 00:00:00'), (2, 'u2', 0, 3, '2024-03-06 00:00:00'), (1, 'u1', 1, 1, '2024-03-07 00:00:00'), (1, 'u2', 2, 2, '2024-03-08 00:00:00');
+	INSERT INTO playerTimeTrials VALUES ('u1', 5, 100, '2024-01-01 00:00:00'), ('u2', 5, 90, '2024-01-02 00:00:00'), ('u1', 6, 50, '2024-01-03 00:00:00'), ('u3', 5, 80, datetime('now'));
+	INSERT INTO playerMinigameScores VALUES ('u1', '2kki', 'mg1', 10, '2024-01-01 00:00:00'), ('u2', '2kki', 'mg1', 20, '2024-01-01 00:00:00'), ('u1', '2kki', 'mg2', 30, '2024-01-02 00:00:00');
+`
+
+// openTestStore creates a migrated SQLite store in a temporary directory holding the given source rows.
+func openTestStore(t *testing.T, sourceRows string) *SqlStore {
+	t.Helper()
+
+	s, err := openSqlite(filepath.Join(t.TempDir(), "ynodb.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.conn.Close() })
+
+	s.pageSize = 25
+	s.snapshotRetention = 365 * 24 * time.Hour
+
+	if err := s.MigrateUp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.conn.Exec(sourceRows); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// testLeaderboard is a leaderboard listed in a game's tree, by the stored category id.
+type testLeaderboard struct {
+	categoryId  string
+	subCategory common.RankingSubCategory
+	composite   bool
+}
+
+// writeTestTrees stores every game's category tree the way the ranking scheduler does and returns the
+// leaderboards listed in them, each once.
+func writeTestTrees(t *testing.T, s *SqlStore, windows []categories.Window) (leaderboards []testLeaderboard) {
+	t.Helper()
+	ctx := context.Background()
+
+	listed := make(map[string]bool)
+	for _, gameName := range common.GameNames {
+		order := 0
+		for _, definition := range categories.All() {
+			if !categories.IsEnabledForGame(definition, gameName) {
+				continue
+			}
+
+			subCategories, err := categories.ListSubCategories(ctx, definition, s, gameName, windows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(subCategories) == 0 {
+				continue
+			}
+
+			categoryId := definition.Id()
+			game := ""
+			if definition.PerGame() {
+				game = gameName
+			}
+			if definition.SeparateByGame() {
+				categoryId += "_" + gameName
+			}
+
+			if err := s.WriteRankingCategory(ctx, categoryId, game, order, definition.Periodic()); err != nil {
+				t.Fatal(err)
+			}
+			order++
+
+			for sc, subCategory := range subCategories {
+				if err := s.WriteRankingSubCategory(ctx, categoryId, subCategory.SubCategoryId, subCategory.Game, subCategory.Window, sc); err != nil {
+					t.Fatal(err)
+				}
+				if !listed[categoryId+"/"+subCategory.SubCategoryId] {
+					listed[categoryId+"/"+subCategory.SubCategoryId] = true
+					leaderboards = append(leaderboards, testLeaderboard{categoryId: categoryId, subCategory: subCategory, composite: definition.Composite()})
+				}
+			}
+		}
+	}
+
+	return leaderboards
+}
+
+// rebuildTestLeaderboards rebuilds composite leaderboards after the others, as the ranking scheduler does.
+func rebuildTestLeaderboards(t *testing.T, s *SqlStore, leaderboards []testLeaderboard) {
+	t.Helper()
+
+	for _, composite := range []bool{false, true} {
+		for _, leaderboard := range leaderboards {
+			if leaderboard.composite != composite {
+				continue
+			}
+			if err := s.UpdateRankingEntries(context.Background(), leaderboard.categoryId, leaderboard.subCategory.SubCategoryId, leaderboard.subCategory.Game); err != nil {
+				t.Fatalf("%s/%s: %v", leaderboard.categoryId, leaderboard.subCategory.SubCategoryId, err)
+			}
+		}
+	}
+}
+
+// listTestLeaderboard lists a leaderboard as "name position value" entries for comparing.
+func listTestLeaderboard(t *testing.T, s *SqlStore, categoryId string, subCategoryId string) []string {
+	t.Helper()
+
+	rankings, err := s.GetRankingsPaged(context.Background(), "2kki", categoryId, subCategoryId, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []string
+	for _, ranking := range rankings {
+		value := fmt.Sprint(ranking.ValueInt)
+		if ranking.ValueFloat != 0 {
+			value = fmt.Sprintf("%.4f", ranking.ValueFloat)
+		}
+		entries = append(entries, fmt.Sprint(ranking.Name, " ", ranking.Position, " ", value))
+	}
+
+	return entries
+}
+
+func TestBuiltInCategories(t *testing.T) {
+	s := openTestStore(t, testSourceRows)
+	rebuildTestLeaderboards(t, s, writeTestTrees(t, s, categories.Windows))
+
+	tests := []struct {
+		categoryId    string
+		subCategoryId string
+		want          []string
+	}{
+		// Tied players reached their value in order
+		{"bp", "all", []string{"bob 1 30", "alice 1 30", "carol 3 5"}},
+		{"bp", "2kki", []string{"bob 1 30", "alice 1 30"}},
+		{"bp", "all_day", nil},
+		{"badgeCount", "all", []string{"bob 1 2", "alice 1 2", "carol 3 1"}},
+		{"badgeCompletion", "all", []string{"bob 1 0.5000", "alice 1 0.5000", "carol 1 0.5000"}},
+		{"badgeCompletion", "2kki", []string{"bob 1 1.0000", "alice 1 1.0000"}},
+		{"badgeRarity", "all", []string{"bob 1 2.0000", "alice 1 2.0000", "carol 3 1.0000"}},
+		{"firstUnlocks_2kki", "first", []string{"bob 1 2"}},
+		{"firstUnlocks_2kki", "top3", []string{"bob 1 2", "alice 1 2"}},
+		{"firstUnlocks_yume", "first", []string{"carol 1 1"}},
+		{"exp", "all", []string{"alice 1 6", "bob 2 5"}},
+		{"exp", "1", []string{"alice 1 3"}},
+		{"exp", "2", []string{"bob 1 5", "alice 2 3"}},
+		{"eventLocationCount", "2", []string{"alice 1 1", "bob 1 1"}},
+		{"freeEventLocationCount_2kki", "2", []string{"alice 1 1"}},
+		{"eventLocationCompletion", "all", []string{"alice 1 1.0000", "bob 2 0.5000"}},
+		{"eventVmCount", "2", []string{"bob 1 1"}},
+		{"eventStreak_2kki", "longest", []string{"alice 1 2", "bob 2 1"}},
+		{"eventStreak_2kki", "current", []string{"alice 1 2", "bob 2 1"}},
+		{"timeTrial", "5", []string{"carol 1 80", "bob 2 90", "alice 3 100"}},
+		// Without a penalty, only players who completed every map are ranked on the total
+		{"timeTrial", "total", []string{"alice 1 150"}},
+		// Windowed totals only count the maps played within the window
+		{"timeTrial", "total_day", []string{"carol 1 80"}},
+		{"minigame", "mg1", []string{"bob 1 20", "alice 2 10"}},
+		{"minigame", "all_2kki", []string{"alice 1 1500", "bob 2 1000"}},
+		// Scored on bp, badgeCount, exp, timeTrial and minigame, leaving out the time trial total and the
+		// combined minigame leaderboard
+		{"overall_2kki", "all", []string{"alice 1 0.8833", "bob 2 0.6667", "carol 3 0.1000"}},
+	}
+
+	for _, test := range tests {
+		got := listTestLeaderboard(t, s, test.categoryId, test.subCategoryId)
+		if strings.Join(got, ", ") != strings.Join(test.want, ", ") {
+			t.Errorf("%s/%s: got %q, want %q", test.categoryId, test.subCategoryId, got, test.want)
+		}
+	}
+}
+
+func TestTimeTrialPenalty(t *testing.T) {
+	categories.TimeTrialPenalty = time.Minute
+	defer func() { categories.TimeTrialPenalty = 0 }()
+
+	s := openTestStore(t, testSourceRows)
+	if err := s.UpdateRankingEntries(context.Background(), "timeTrial", "total", "2kki"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing maps count as the penalty
+	want := []string{"carol 1 140", "bob 2 150", "alice 2 150"}
+	if got := listTestLeaderboard(t, s, "timeTrial", "total"); strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCategoryTrees(t *testing.T) {
+	s := openTestStore(t, testSourceRows)
+	rebuildTestLeaderboards(t, s, writeTestTrees(t, s, nil))
+
+	for _, gameName := range common.GameNames {
+		rankingCategories, err := s.GetRankingCategories(context.Background(), gameName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listed := make(map[string][]string)
+		for _, category := range rankingCategories {
+			if category.Game != "" && category.Game != gameName {
+				t.Errorf("%s lists %s of %s", gameName, category.CategoryId, category.Game)
+			}
+			for _, subCategory := range category.SubCategories {
+				if subCategory.Game != "" && subCategory.Game != gameName {
+					t.Errorf("%s lists %s/%s of %s", gameName, category.CategoryId, subCategory.SubCategoryId, subCategory.Game)
+				}
+				listed[category.CategoryId] = append(listed[category.CategoryId], subCategory.SubCategoryId)
+			}
+		}
+
+		switch gameName {
+		case "2kki":
+			if got := strings.Join(listed["eventStreak_2kki"], ","); got != "longest,current" {
+				t.Errorf("2kki lists streaks %q, want longest,current", got)
+			}
+			if got := strings.Join(listed["timeTrial"], ","); got != "6,5,total" && got != "5,6,total" {
+				t.Errorf("2kki lists time trials %q, want each map then the total", got)
+			}
+		case "yume":
+			for categoryId := range listed {
+				if strings.HasPrefix(categoryId, "eventStreak") || categoryId == "timeTrial" || categoryId == "minigame" {
+					t.Errorf("yume lists %s", categoryId)
+				}
+			}
+			if got := strings.Join(listed["bp"], ","); got != "all,yume" {
+				t.Errorf("yume lists bp %q, want all,yume", got)
+			}
+		}
+	}
+}
+
+func TestFinalizeAndLock(t *testing.T) {
+	s := openTestStore(t, testSourceRows)
+	rebuildTestLeaderboards(t, s, writeTestTrees(t, s, nil))
+	ctx := context.Background()
+
+	if err := s.FinalizeRankingEntries(ctx, "exp", "1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateRankingEntries(ctx, "exp", "1", ""); !errors.Is(err, ErrFinalized) {
+		t.Errorf("rebuilding a finalized leaderboard: got %v, want %v", err, ErrFinalized)
+	}
+	// Finalizing again recomputes it
+	if err := s.FinalizeRankingEntries(ctx, "exp", "1", ""); err != nil {
+		t.Errorf("finalizing again: %v", err)
+	}
+
+	locked, err := s.LockRankingEntries(ctx, "exp", "2")
+	if err != nil || !locked {
+		t.Fatalf("locking exp/2: got %v, %v; want it locked", locked, err)
+	}
+	if _, err := s.conn.Exec("DELETE FROM eventCompletions"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateRankingEntries(ctx, "exp", "2", ""); !errors.Is(err, ErrFinalized) {
+		t.Errorf("rebuilding a locked leaderboard: got %v, want %v", err, ErrFinalized)
+	}
+	if got := listTestLeaderboard(t, s, "exp", "2"); len(got) != 2 {
+		t.Errorf("locked leaderboard lists %q, want it left as it was", got)
+	}
+
+	// Leaderboards without entries aren't locked
+	locked, err = s.LockRankingEntries(ctx, "eventVmCount", "1")
+	if err != nil || locked {
+		t.Errorf("locking an empty leaderboard: got %v, %v; want it left unlocked", locked, err)
+	}
+	if finalized, err := s.IsRankingFinalized(ctx, "eventVmCount", "1"); err != nil || finalized {
+		t.Errorf("got finalized %v, %v for an empty leaderboard", finalized, err)
+	}
+}
+
+func TestPositionChangesAndSnapshots(t *testing.T) {
+	s := openTestStore(t, testSourceRows)
+	ctx := context.Background()
+
+	if err := s.UpdateRankingEntries(ctx, "bp", "all", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice overtakes bob, who loses a badge, and dave is new
+	if _, err := s.conn.Exec(`
+		DELETE FROM playerBadges WHERE uuid = 'u2' AND badgeId = 'b2';
+		INSERT INTO accounts (uuid, user) VALUES ('u4', 'dave');
+		INSERT INTO players (uuid) VALUES ('u4');
+		INSERT INTO playerBadges VALUES ('u4', 'b3', '2024-04-01 00:00:00');
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateRankingEntries(ctx, "bp", "all", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	rankings, err := s.GetRankingsPaged(ctx, "2kki", "bp", "all", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		name             string
+		position         int
+		previousPosition int
+		positionChange   int
+		new              bool
+	}{
+		{"alice", 1, 1, 0, false},
+		{"bob", 2, 1, -1, false},
+		{"carol", 3, 3, 0, false},
+		{"dave", 3, 0, 0, true},
+	}
+	if len(rankings) != len(want) {
+		t.Fatalf("got %d rankings, want %d", len(rankings), len(want))
+	}
+	for i, ranking := range rankings {
+		if ranking.Name != want[i].name || ranking.Position != want[i].position || ranking.PreviousPosition != want[i].previousPosition || ranking.PositionChange != want[i].positionChange || ranking.New != want[i].new {
+			t.Errorf("entry %d: got %s at %d, previously %d, change %d, new %v; want %+v", i, ranking.Name, ranking.Position, ranking.PreviousPosition, ranking.PositionChange, ranking.New, want[i])
+		}
+	}
+
+	// Today's snapshot is replaced by each rebuild
+	snapshot, err := s.GetRankingsPagedAt(ctx, "2kki", "bp", "all", 1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot) != len(rankings) || snapshot[1].Name != "bob" || snapshot[1].ValueInt != 10 {
+		t.Errorf("got snapshot %+v, want the latest leaderboard", snapshot)
+	}
+	if before, err := s.GetRankingsPagedAt(ctx, "2kki", "bp", "all", 1, time.Now().AddDate(0, 0, -1)); err != nil || len(before) != 0 {
+		t.Errorf("got %d rankings, %v before the first snapshot; want none", len(before), err)
+	}
+}
+
+func TestAggregateSubCategoriesDontAwardMedals(t *testing.T) {
+	s := openTestStore(t, testSourceRows)
+	rebuildTestLeaderboards(t, s, writeTestTrees(t, s, nil))
+	ctx := context.Background()
+
+	diamonds := func() (count int) {
+		t.Helper()
+		if err := s.UpdatePlayerMedals(ctx, "2kki"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.conn.QueryRow("SELECT medalCountDiamond FROM playerGameData WHERE uuid = 'u1' AND game = '2kki'").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	// alice tops both the combined minigame leaderboard and the time trial total
+	for _, leaderboard := range []string{"minigame/all_2kki", "timeTrial/total"} {
+		categoryId, subCategoryId, _ := strings.Cut(leaderboard, "/")
+		if got := listTestLeaderboard(t, s, categoryId, subCategoryId); len(got) == 0 || !strings.HasPrefix(got[0], "alice 1 ") {
+			t.Fatalf("%s: got %q, want alice first", leaderboard, got)
+		}
+	}
+
+	want := diamonds()
+	if _, err := s.conn.Exec("DELETE FROM rankingEntries WHERE (categoryId = 'minigame' AND subCategoryId = 'all_2kki') OR (categoryId = 'timeTrial' AND subCategoryId = 'total')"); err != nil {
+		t.Fatal(err)
+	}
+	if got := diamonds(); got != want {
+		t.Errorf("alice has %d diamond medals, %d of them from aggregate subcategories", want, want-got)
+	}
+}
+
+func TestRankingStandingCountsTies(t *testing.T) {
+	s := openTestStore(t, testSourceRows)
+	ctx := context.Background()
+
+	if err := s.UpdateRankingEntries(ctx, "bp", "all", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		uuid       string
+		position   int
+		percentile float32
+		tier       string
+	}{
+		// bob and alice share first place, so both are in the top two thirds
+		{"u2", 1, 200.0 / 3, "diamond"},
+		{"u1", 1, 200.0 / 3, "platinum"},
+		{"u3", 3, 100, "platinum"},
+		{"u4", 0, 0, ""},
+	}
+
+	for _, test := range tests {
+		standing, err := s.GetRankingStanding(ctx, test.uuid, "bp", "all")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if standing.Position != test.position || standing.Total != 3 || standing.Percentile != test.percentile || standing.Tier != test.tier {
+			t.Errorf("%s: got %+v, want position %d of 3, percentile %g, tier %q", test.uuid, standing, test.position, test.percentile, test.tier)
+		}
+	}
+}
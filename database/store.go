@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ynoproject/ynorankings/common"
+)
+
+// ErrTimeout is returned when a query runs past the deadline of its context.
+var ErrTimeout = errors.New("database query timed out")
+
+// ErrFinalized is returned when updating a leaderboard that has been finalized.
+var ErrFinalized = errors.New("leaderboard is finalized")
+
+// RankingStore is the storage layer used by the API, the ranking scheduler and the CLI.
+type RankingStore interface {
+	Migrator
+
+	GetPlayerUuidFromToken(ctx context.Context, token string) (uuid string, err error)
+	GetEventPeriodData(ctx context.Context, gameName string) (eventPeriods []*common.EventPeriod, err error)
+	GetCurrentEventPeriodOrdinal(ctx context.Context) (periodOrdinal int, err error)
+	GetTimeTrialMapIds(ctx context.Context) (mapIds []int, err error)
+	GetGameMinigameIds(ctx context.Context, gameName string) (minigameIds []string, err error)
+	GetSourceWatermark(ctx context.Context, source string, gameName string) (watermark string, err error)
+
+	GetRankingCategories(ctx context.Context, gameName string) (rankingCategories []*common.RankingCategory, err error)
+	WriteRankingCategory(ctx context.Context, categoryId string, game string, order int, periodic bool) (err error)
+	WriteRankingSubCategory(ctx context.Context, categoryId string, subCategoryId string, game string, window string, order int) (err error)
+
+	GetRankingEntryPage(ctx context.Context, playerUuid string, categoryId string, subCategoryId string) (page int, err error)
+	GetRankingsPaged(ctx context.Context, gameName string, categoryId string, subCategoryId string, page int) (rankings []*common.Ranking, err error)
+	GetRankingStanding(ctx context.Context, playerUuid string, categoryId string, subCategoryId string) (standing *common.RankingStanding, err error)
+	GetBadgeFirstUnlockers(ctx context.Context, badgeId string) (unlockers []*common.BadgeUnlocker, err error)
+	UpdateRankingEntries(ctx context.Context, categoryId string, subCategoryId string, gameId string) (err error)
+	UpdatePlayerMedals(ctx context.Context, gameName string) (err error)
+
+	FinalizeRankingEntries(ctx context.Context, categoryId string, subCategoryId string, gameId string) (err error)
+	IsRankingFinalized(ctx context.Context, categoryId string, subCategoryId string) (finalized bool, err error)
+	LockRankingEntries(ctx context.Context, categoryId string, subCategoryId string) (locked bool, err error)
+
+	GetRankingsPagedAt(ctx context.Context, gameName string, categoryId string, subCategoryId string, page int, at time.Time) (rankings []*common.Ranking, err error)
+	PruneRankingSnapshots(ctx context.Context, before time.Time) (err error)
+}
+
+// Migrator manages the versioned schema of the ranking tables.
+type Migrator interface {
+	MigrateUp(ctx context.Context) (err error)
+	MigrateDown(ctx context.Context) (err error)
+	GetMigrationStatus(ctx context.Context) (statuses []*MigrationStatus, err error)
+	CheckSchemaVersion(ctx context.Context) (err error)
+}
+
+// wrapTimeout turns a deadline hit by ctx into ErrTimeout, keeping the driver error for context.
+// Drivers don't agree on what they return when a query is interrupted, so ctx is checked as well.
+func wrapTimeout(ctx context.Context, err *error) {
+	if *err == nil || errors.Is(*err, ErrTimeout) {
+		return
+	}
+	if errors.Is(*err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		*err = fmt.Errorf("%w: %w", ErrTimeout, *err)
+	}
+}
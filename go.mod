@@ -4,9 +4,10 @@ go 1.22
 
 require github.com/go-co-op/gocron v1.18.0
 
+require github.com/robfig/cron/v3 v3.0.1 // indirect
+
 require (
-	github.com/robfig/cron/v3 v3.0.1 // indirect
-	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/mattn/go-sqlite3 v1.14.22
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 )
-
-require github.com/go-sql-driver/mysql v1.6.0
@@ -1,15 +1,46 @@
 package main
 
 import (
+	"context"
+	"log"
+	"time"
+
 	"github.com/ynoproject/ynorankings/api"
+	"github.com/ynoproject/ynorankings/cache"
+	"github.com/ynoproject/ynorankings/categories"
 	"github.com/ynoproject/ynorankings/cli"
+	"github.com/ynoproject/ynorankings/common"
+	"github.com/ynoproject/ynorankings/config"
 	"github.com/ynoproject/ynorankings/database"
 	"github.com/ynoproject/ynorankings/rankings"
 )
 
 func main() {
-	database.Init()
-	cli.Run()
-	rankings.Init()
-	api.Init()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	common.GameNames = cfg.Games
+	categories.TimeTrialPenalty = time.Duration(cfg.TimeTrialPenalty)
+
+	if cfg.CategoriesFile != "" {
+		if err := categories.LoadFile(cfg.CategoriesFile, cfg.Games); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := categories.RegisterOverall(cfg.Overall.Weights, categories.Normalization(cfg.Overall.Normalization)); err != nil {
+		log.Fatal(err)
+	}
+
+	store := cache.NewStore(database.Init(cfg), cfg)
+	cli.Run(cfg, store)
+
+	if err := store.CheckSchemaVersion(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+
+	rankings.Init(cfg, store)
+	api.Init(cfg, store)
 }
@@ -1,11 +1,20 @@
 package rankings
 
 import (
+	"context"
 	"log"
+	"maps"
+	"runtime/debug"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ynoproject/ynorankings/categories"
 	"github.com/ynoproject/ynorankings/common"
+	"github.com/ynoproject/ynorankings/config"
 	"github.com/ynoproject/ynorankings/database"
 
 	"github.com/go-co-op/gocron"
@@ -13,167 +22,450 @@ import (
 
 var (
 	scheduler = gocron.NewScheduler(time.UTC)
-)
 
-func Init() {
-	common.CurrentEventPeriodOrdinal, _ = database.GetCurrentEventPeriodOrdinal()
+	// workers bounds how many leaderboards are rebuilt at once across the update jobs, holding a slot each
+	workers chan struct{}
 
-	for _, gameName := range common.GameNames {
-		var rankingCategories []*common.RankingCategory
+	// watermarks holds the watermark each leaderboard was last rebuilt at, by category and subcategory id;
+	// it starts empty so every leaderboard is rebuilt once after a restart
+	watermarks      = make(map[string]string)
+	watermarksMutex sync.Mutex
 
-		bpCategory := &common.RankingCategory{CategoryId: "bp"}
-		rankingCategories = append(rankingCategories, bpCategory)
+	// componentRebuilds counts the rebuilds of lifetime and event period leaderboards, which composite
+	// categories are computed from, by the game of their subcategory
+	componentRebuilds = make(map[string]int)
 
-		badgeCountCategory := &common.RankingCategory{CategoryId: "badgeCount"}
-		rankingCategories = append(rankingCategories, badgeCountCategory)
+	// currentEventPeriods holds the event periods seen as the current one by this process, whose leaderboards
+	// are rebuilt one last time when finalized; those of earlier periods are locked as they stand. Only the
+	// main update job uses it.
+	currentEventPeriods = make(map[int]bool)
 
-		bpCategory.SubCategories = append(bpCategory.SubCategories, common.RankingSubCategory{SubCategoryId: "all"})
-		badgeCountCategory.SubCategories = append(badgeCountCategory.SubCategories, common.RankingSubCategory{SubCategoryId: "all"})
+	// updateTotals adds up the counts of the update runs since startup
+	updateTotals      = make(map[string]common.UpdateStats)
+	updateTotalsMutex sync.Mutex
+)
 
-		bpCategory.SubCategories = append(bpCategory.SubCategories, common.RankingSubCategory{SubCategoryId: gameName, Game: gameName})
-		badgeCountCategory.SubCategories = append(badgeCountCategory.SubCategories, common.RankingSubCategory{SubCategoryId: gameName, Game: gameName})
+func Init(cfg config.Config, store database.RankingStore) {
+	ctx := context.Background()
 
-		eventPeriods, err := database.GetEventPeriodData(gameName)
-		if err != nil {
-			log.Print("SERVER ", "exp", err.Error())
-		} else if len(eventPeriods) > 0 {
-			expCategory := &common.RankingCategory{CategoryId: "exp", Periodic: true}
-			rankingCategories = append(rankingCategories, expCategory)
+	if periodOrdinal, err := store.GetCurrentEventPeriodOrdinal(ctx); err == nil {
+		common.CurrentEventPeriodOrdinal.Store(int64(periodOrdinal))
+	}
 
-			if len(eventPeriods) > 1 {
-				expCategory.SubCategories = append(expCategory.SubCategories, common.RankingSubCategory{SubCategoryId: "all"})
-			}
-			for _, eventPeriod := range eventPeriods {
-				expCategory.SubCategories = append(expCategory.SubCategories, common.RankingSubCategory{SubCategoryId: strconv.Itoa(eventPeriod.PeriodOrdinal)})
-			}
+	var windows []categories.Window
+	for _, window := range categories.Windows {
+		if cfg.WindowUpdateIntervals[string(window)] > 0 {
+			windows = append(windows, window)
+		}
+	}
 
-			eventLocationCountCategory := &common.RankingCategory{CategoryId: "eventLocationCount", Periodic: true}
-			rankingCategories = append(rankingCategories, eventLocationCountCategory)
+	writeRankingCategories(ctx, store, windows)
 
-			if len(eventPeriods) > 1 {
-				eventLocationCountCategory.SubCategories = append(eventLocationCountCategory.SubCategories, common.RankingSubCategory{SubCategoryId: "all"})
-			}
-			for _, eventPeriod := range eventPeriods {
-				eventLocationCountCategory.SubCategories = append(eventLocationCountCategory.SubCategories, common.RankingSubCategory{SubCategoryId: strconv.Itoa(eventPeriod.PeriodOrdinal)})
-			}
+	workers = make(chan struct{}, cfg.UpdateConcurrency)
 
-			freeEventLocationCountCategory := &common.RankingCategory{CategoryId: "freeEventLocationCount", Game: gameName, Periodic: true, SeparateByGame: true}
-			rankingCategories = append(rankingCategories, freeEventLocationCountCategory)
+	scheduler.Every(time.Duration(cfg.UpdateInterval)).SingletonMode().Do(func() {
+		updateRankings(cfg, store, "")
+	})
 
-			if len(eventPeriods) > 1 {
-				freeEventLocationCountCategory.SubCategories = append(freeEventLocationCountCategory.SubCategories, common.RankingSubCategory{SubCategoryId: "all", Game: gameName})
-			}
-			for _, eventPeriod := range eventPeriods {
-				freeEventLocationCountCategory.SubCategories = append(freeEventLocationCountCategory.SubCategories, common.RankingSubCategory{SubCategoryId: strconv.Itoa(eventPeriod.PeriodOrdinal), Game: gameName})
-			}
+	for _, window := range windows {
+		// The interval and rollover jobs are scheduled separately, so they share a lock to never rebuild the
+		// same leaderboards at once
+		var mutex sync.Mutex
+		update := func() {
+			mutex.Lock()
+			defer mutex.Unlock()
 
-			if gameName == "2kki" {
-				eventLocationCompletionCategory := &common.RankingCategory{CategoryId: "eventLocationCompletion", Game: gameName, Periodic: true}
-				rankingCategories = append(rankingCategories, eventLocationCompletionCategory)
+			updateRankings(cfg, store, window)
+		}
 
-				if len(eventPeriods) > 1 {
-					eventLocationCompletionCategory.SubCategories = append(eventLocationCompletionCategory.SubCategories, common.RankingSubCategory{SubCategoryId: "all", Game: gameName})
-				}
-				for _, eventPeriod := range eventPeriods {
-					eventLocationCompletionCategory.SubCategories = append(eventLocationCompletionCategory.SubCategories, common.RankingSubCategory{SubCategoryId: strconv.Itoa(eventPeriod.PeriodOrdinal), Game: gameName})
-				}
-			}
+		scheduler.Every(time.Duration(cfg.WindowUpdateIntervals[string(window)])).SingletonMode().Do(update)
 
-			eventVmCountCategory := &common.RankingCategory{CategoryId: "eventVmCount", Periodic: true}
-			rankingCategories = append(rankingCategories, eventVmCountCategory)
+		// Start the new window's leaderboards right away rather than at the next scheduled rebuild
+		switch window {
+		case categories.WindowDay:
+			scheduler.Every(1).Day().At("00:00").Do(update)
+		case categories.WindowWeek:
+			scheduler.Every(1).Monday().At("00:00").Do(update)
+		case categories.WindowMonth:
+			scheduler.Every(1).Month(1).At("00:00").Do(update)
+		}
+	}
 
-			for _, eventPeriod := range eventPeriods {
-				if eventPeriod.EnableVms {
-					eventVmCountCategory.SubCategories = append(eventVmCountCategory.SubCategories, common.RankingSubCategory{SubCategoryId: strconv.Itoa(eventPeriod.PeriodOrdinal)})
-				}
+	if cfg.SnapshotRetention > 0 {
+		scheduler.Every(1).Day().SingletonMode().Do(func() {
+			pruneCtx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeouts.Category))
+			err := store.PruneRankingSnapshots(pruneCtx, time.Now().Add(-time.Duration(cfg.SnapshotRetention)))
+			cancel()
+			if err != nil {
+				log.Print("SERVER ", "snapshots", err.Error())
 			}
+		})
+	}
 
-			if len(eventVmCountCategory.SubCategories) > 1 {
-				eventVmCountCategory.SubCategories = append([]common.RankingSubCategory{{SubCategoryId: "all"}}, eventVmCountCategory.SubCategories...)
-			}
-		}
+	scheduler.StartAsync()
+}
 
-		if gameName == "2kki" {
-			timeTrialMapIds, err := database.GetTimeTrialMapIds()
-			if err != nil {
-				log.Print("SERVER ", "timeTrial", err.Error())
-			} else if len(timeTrialMapIds) > 0 {
-				timeTrialCategory := &common.RankingCategory{CategoryId: "timeTrial", Game: gameName}
-				rankingCategories = append(rankingCategories, timeTrialCategory)
+// writeRankingCategories lists the categories and subcategories of every game in common.GameRankingCategories
+// and stores them for the API, dropping categories without subcategories from a game's tree. Event period
+// categories shared by all games are stored from the first game listing them, since games list different periods.
+func writeRankingCategories(ctx context.Context, store database.RankingStore, windows []categories.Window) {
+	writtenPeriodic := make(map[string]bool)
 
-				for _, mapId := range timeTrialMapIds {
-					timeTrialCategory.SubCategories = append(timeTrialCategory.SubCategories, common.RankingSubCategory{SubCategoryId: strconv.Itoa(mapId), Game: gameName})
-				}
+	for _, gameName := range common.GameNames {
+		var rankingCategories []*common.RankingCategory
+
+		for _, definition := range categories.All() {
+			if !categories.IsEnabledForGame(definition, gameName) {
+				continue
 			}
-		}
 
-		gameMinigameIds, err := database.GetGameMinigameIds(gameName)
-		if err != nil {
-			log.Print("SERVER ", "minigame", err.Error())
-		} else {
-			minigameCategory := &common.RankingCategory{CategoryId: "minigame", Game: gameName}
-			rankingCategories = append(rankingCategories, minigameCategory)
+			subCategories, err := categories.ListSubCategories(ctx, definition, store, gameName, windows)
+			if err != nil {
+				log.Print("SERVER ", definition.Id(), err.Error())
+				continue
+			}
+			if len(subCategories) == 0 {
+				continue
+			}
 
-			for _, minigameId := range gameMinigameIds {
-				minigameCategory.SubCategories = append(minigameCategory.SubCategories, common.RankingSubCategory{SubCategoryId: minigameId, Game: gameName})
+			category := &common.RankingCategory{CategoryId: definition.Id(), SubCategories: subCategories, Periodic: definition.Periodic(), SeparateByGame: definition.SeparateByGame(), Composite: definition.Composite()}
+			if definition.PerGame() {
+				category.Game = gameName
 			}
+
+			rankingCategories = append(rankingCategories, category)
 		}
 
 		for c, category := range rankingCategories {
 			categoryId := category.CategoryId
 			if category.SeparateByGame {
 				categoryId += "_" + category.Game
-			} else if category.Periodic && category.Game == "" && gameName != "2kki" {
-				continue
+			} else if category.Periodic && category.Game == "" {
+				if writtenPeriodic[categoryId] {
+					continue
+				}
+				writtenPeriodic[categoryId] = true
 			}
-			err := database.WriteRankingCategory(categoryId, category.Game, c)
+			err := store.WriteRankingCategory(ctx, categoryId, category.Game, c, category.Periodic)
 			if err != nil {
 				log.Print("SERVER ", categoryId, err.Error())
 				continue
 			}
 			for sc, subCategory := range category.SubCategories {
-				err = database.WriteRankingSubCategory(categoryId, subCategory.SubCategoryId, subCategory.Game, sc)
+				err = store.WriteRankingSubCategory(ctx, categoryId, subCategory.SubCategoryId, subCategory.Game, subCategory.Window, sc)
 				if err != nil {
 					log.Print("SERVER ", categoryId+"/"+subCategory.SubCategoryId, err.Error())
 				}
 			}
 		}
 
+		// Composite categories are computed from the others, so they're rebuilt last
+		sort.SliceStable(rankingCategories, func(i, j int) bool {
+			return !rankingCategories[i].Composite && rankingCategories[j].Composite
+		})
+
 		common.GameRankingCategories[gameName] = rankingCategories
 	}
+}
+
+// updateRankings rebuilds every leaderboard of the given window whose sources changed since its last rebuild,
+// or the lifetime and event period ones followed by the medal counts when window is empty. Leaderboards of
+// event periods that have ended since the last run are finalized instead.
+//
+// Leaderboards are rebuilt concurrently on the workers, composite ones once all others are done. Medal counts
+// include the leaderboards shared by all games, so they're only updated once every leaderboard is.
+func updateRankings(cfg config.Config, store database.RankingStore, window categories.Window) {
+	ctx := context.Background()
+
+	if window == "" {
+		periodOrdinal, err := store.GetCurrentEventPeriodOrdinal(ctx)
+		if err != nil {
+			log.Print("SERVER ", "event period", err.Error())
+		} else {
+			common.CurrentEventPeriodOrdinal.Store(int64(periodOrdinal))
+		}
+	}
+	currentEventPeriodOrdinal := int(common.CurrentEventPeriodOrdinal.Load())
+	if window == "" && currentEventPeriodOrdinal > 0 {
+		currentEventPeriods[currentEventPeriodOrdinal] = true
+	}
+
+	sourceWatermarks := &sourceWatermarks{values: make(map[string]string)}
+	stats := &updateStats{}
+
+	var leaderboardJobs, compositeJobs []job
+	queued := make(map[string]bool)
+
+	for _, gameName := range common.GameNames {
+		endedEventPeriods := make(map[int]bool)
+		if window == "" {
+			eventPeriods, err := store.GetEventPeriodData(ctx, gameName)
+			if err != nil {
+				log.Print("SERVER ", gameName+"/event periods", err.Error())
+			}
+			for _, eventPeriod := range eventPeriods {
+				endedEventPeriods[eventPeriod.PeriodOrdinal] = !time.Now().Before(eventPeriod.EndDate)
+			}
+		}
 
-	scheduler.Every(15).Minutes().SingletonMode().Do(func() {
-		for _, gameName := range common.GameNames {
-			for _, category := range common.GameRankingCategories[gameName] {
-				categoryId := category.CategoryId
-				if category.SeparateByGame {
-					categoryId += "_" + category.Game
+		for _, category := range common.GameRankingCategories[gameName] {
+			categoryId := category.CategoryId
+			if category.SeparateByGame {
+				categoryId += "_" + category.Game
+			}
+			for _, subCategory := range category.SubCategories {
+				if categories.Window(subCategory.Window) != window {
+					continue
+				}
+				// Leaderboards shared by games are listed in each of their trees
+				name := categoryId + "/" + subCategory.SubCategoryId
+				if queued[name] {
+					continue
 				}
-				for _, subCategory := range category.SubCategories {
-					// Use Yume 2kki server to update 'all' rankings
-					if subCategory.SubCategoryId == "all" && !category.SeparateByGame && gameName != "2kki" {
+				baseSubCategoryId, _ := categories.SplitWindow(subCategory.SubCategoryId)
+				if category.Periodic && baseSubCategoryId != "all" {
+					eventPeriodOrdinal, errconv := strconv.Atoi(baseSubCategoryId)
+					if errconv != nil {
 						continue
 					}
-					if category.Periodic && subCategory.SubCategoryId != "all" {
-						eventPeriodOrdinal, errconv := strconv.Atoi(subCategory.SubCategoryId)
-						if errconv != nil || eventPeriodOrdinal != common.CurrentEventPeriodOrdinal {
-							continue
+					if eventPeriodOrdinal != currentEventPeriodOrdinal {
+						if endedEventPeriods[eventPeriodOrdinal] {
+							queued[name] = true
+							recompute := currentEventPeriods[eventPeriodOrdinal]
+							leaderboardJobs = append(leaderboardJobs, job{name: gameName + "/" + name, run: func() {
+								finalizeRankings(cfg, store, gameName, categoryId, subCategory, recompute)
+							}})
 						}
+						continue
 					}
+				}
 
-					err := database.UpdateRankingEntries(categoryId, subCategory.SubCategoryId, subCategory.Game)
-					if err != nil {
-						log.Print("SERVER ", gameName+"/"+categoryId+"/"+subCategory.SubCategoryId, err.Error())
-					}
+				queued[name] = true
+				leaderboardJob := job{name: gameName + "/" + name, run: func() {
+					updateCtx, cancel := context.WithTimeout(ctx, cfg.Timeouts.CategoryTimeout(categoryId))
+					defer cancel()
+					updateLeaderboard(updateCtx, store, gameName, categoryId, subCategory, window, sourceWatermarks, stats)
+				}}
+				if category.Composite {
+					compositeJobs = append(compositeJobs, leaderboardJob)
+				} else {
+					leaderboardJobs = append(leaderboardJobs, leaderboardJob)
 				}
 			}
+		}
+	}
+
+	runJobs(leaderboardJobs)
+	runJobs(compositeJobs)
+
+	runName := "rankings"
+	if window != "" {
+		runName += "/" + string(window)
+	}
+	log.Print("SERVER ", runName, ": ", stats.rebuilt.Load(), " rebuilt, ", stats.skipped.Load(), " skipped, ", stats.failed.Load(), " failed")
+	addUpdateStats(runName, stats)
+
+	if window != "" {
+		return
+	}
 
-			err := database.UpdatePlayerMedals(gameName)
+	var medalJobs []job
+	for _, gameName := range common.GameNames {
+		medalJobs = append(medalJobs, job{name: gameName + "/medals", run: func() {
+			medalsCtx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeouts.Category))
+			defer cancel()
+			err := store.UpdatePlayerMedals(medalsCtx, gameName)
 			if err != nil {
-				log.Print("SERVER ", "medals", err.Error())
+				log.Print("SERVER ", gameName+"/medals", err.Error())
 			}
+		}})
+	}
+
+	runJobs(medalJobs)
+}
+
+// updateStats counts the leaderboards of a run that were rebuilt, failed or skipped because their sources
+// hadn't changed.
+type updateStats struct {
+	rebuilt atomic.Int64
+	skipped atomic.Int64
+	failed  atomic.Int64
+}
+
+// addUpdateStats adds the counts of a run to the totals of its kind of run.
+func addUpdateStats(runName string, stats *updateStats) {
+	updateTotalsMutex.Lock()
+	defer updateTotalsMutex.Unlock()
+
+	totals := updateTotals[runName]
+	totals.Runs++
+	totals.Rebuilt += stats.rebuilt.Load()
+	totals.Skipped += stats.skipped.Load()
+	totals.Failed += stats.failed.Load()
+	totals.LastRun = time.Now().UTC()
+	updateTotals[runName] = totals
+}
+
+// GetUpdateStats returns the leaderboard counts of every kind of update run since startup, by run name such as
+// "rankings" or "rankings/day".
+func GetUpdateStats() map[string]common.UpdateStats {
+	updateTotalsMutex.Lock()
+	defer updateTotalsMutex.Unlock()
+
+	return maps.Clone(updateTotals)
+}
+
+// job is a unit of work run by the workers, named for logging.
+type job struct {
+	name string
+	run  func()
+}
+
+// runJobs runs jobs concurrently, as far as the workers allow, and waits for all of them. Jobs report their
+// own errors, and one that panics is logged without affecting the others.
+func runJobs(jobs []job) {
+	var wg sync.WaitGroup
+
+	for _, j := range jobs {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Print("SERVER ", j.name, " panic: ", r, "\n", string(debug.Stack()))
+				}
+				<-workers
+				wg.Done()
+			}()
+			j.run()
+		}()
+	}
+
+	wg.Wait()
+}
+
+// sourceWatermarks summarizes each source for a game, or all of them, once per run, when the first leaderboard
+// computed from it is checked.
+type sourceWatermarks struct {
+	mutex  sync.Mutex
+	values map[string]string
+}
+
+func (w *sourceWatermarks) get(ctx context.Context, store database.RankingStore, source string, gameName string) (watermark string, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	key := source + "/" + gameName
+	watermark, ok := w.values[key]
+	if ok {
+		return watermark, nil
+	}
+
+	watermark, err = store.GetSourceWatermark(ctx, source, gameName)
+	if err != nil {
+		return watermark, err
+	}
+	w.values[key] = watermark
+
+	return watermark, nil
+}
+
+// updateLeaderboard rebuilds a leaderboard unless its watermark is the same as at its last rebuild.
+func updateLeaderboard(ctx context.Context, store database.RankingStore, gameName string, categoryId string, subCategory common.RankingSubCategory, window categories.Window, sourceWatermarks *sourceWatermarks, stats *updateStats) {
+	key := categoryId + "/" + subCategory.SubCategoryId
+
+	watermark, err := getWatermark(ctx, store, categoryId, subCategory.Game, window, sourceWatermarks)
+	if err != nil {
+		log.Print("SERVER ", gameName+"/"+key, " watermark: ", err.Error())
+	}
+
+	watermarksMutex.Lock()
+	unchanged := watermark != "" && watermarks[key] == watermark
+	watermarksMutex.Unlock()
+	if unchanged {
+		stats.skipped.Add(1)
+		return
+	}
+
+	err = store.UpdateRankingEntries(ctx, categoryId, subCategory.SubCategoryId, subCategory.Game)
+	if err != nil {
+		log.Print("SERVER ", gameName+"/"+key, err.Error())
+		stats.failed.Add(1)
+		return
+	}
+
+	stats.rebuilt.Add(1)
+
+	watermarksMutex.Lock()
+	watermarks[key] = watermark
+	if definition, _ := categories.Get(categoryId); window == "" && !definition.Composite() {
+		componentRebuilds[subCategory.Game]++
+	}
+	watermarksMutex.Unlock()
+}
+
+// getWatermark combines the watermarks of a category's sources, limited to the leaderboard's game, plus the current event period for categories
+// computed from event periods and the start of the window for windowed leaderboards, as those lose rows when
+// they roll over. Composite categories are instead rebuilt after any leaderboard of their game or shared by
+// all games. Either way, the date is included so every leaderboard is rebuilt at least once a day. It's empty
+// when the sources aren't known, so the leaderboard is always rebuilt.
+func getWatermark(ctx context.Context, store database.RankingStore, categoryId string, game string, window categories.Window, sourceWatermarks *sourceWatermarks) (watermark string, err error) {
+	definition, ok := categories.Get(categoryId)
+	if !ok {
+		return watermark, nil
+	}
+
+	// Position changes since the previous day and week and the daily snapshots are only recorded on rebuilds
+	parts := []string{"date " + time.Now().UTC().Format(time.DateOnly)}
+
+	if definition.Composite() {
+		watermarksMutex.Lock()
+		defer watermarksMutex.Unlock()
+		parts = append(parts, "rebuilds "+strconv.Itoa(componentRebuilds[""])+","+strconv.Itoa(componentRebuilds[game]))
+		return strings.Join(parts, "; "), nil
+	}
+
+	sourceGame := definition.SourceGame(game)
+	for _, source := range definition.Sources() {
+		sourceWatermark, err := sourceWatermarks.get(ctx, store, source, sourceGame)
+		if err != nil {
+			return watermark, err
 		}
-	})
+		parts = append(parts, source+" "+sourceWatermark)
+		// Leaderboards of the current event period change once the next one starts
+		if source == "eventPeriods" {
+			parts = append(parts, "period "+strconv.FormatInt(common.CurrentEventPeriodOrdinal.Load(), 10))
+		}
+	}
 
-	scheduler.StartAsync()
+	if window != "" {
+		parts = append(parts, string(window)+" "+window.Start(time.Now()).Format(time.DateOnly))
+	}
+
+	return strings.Join(parts, "; "), nil
+}
+
+// finalizeRankings locks the final leaderboard of an ended event period, unless that was already done. Only
+// periods this process saw as current are rebuilt one last time; older ones are locked as they stand rather than
+// recomputed from data that may have changed since, unless they have no entries.
+func finalizeRankings(cfg config.Config, store database.RankingStore, gameName string, categoryId string, subCategory common.RankingSubCategory, recompute bool) {
+	finalizeCtx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.CategoryTimeout(categoryId))
+	defer cancel()
+
+	finalized, err := store.IsRankingFinalized(finalizeCtx, categoryId, subCategory.SubCategoryId)
+	if err == nil && !finalized {
+		var locked bool
+		if !recompute {
+			locked, err = store.LockRankingEntries(finalizeCtx, categoryId, subCategory.SubCategoryId)
+		}
+		if err == nil && !locked {
+			err = store.FinalizeRankingEntries(finalizeCtx, categoryId, subCategory.SubCategoryId, subCategory.Game)
+		}
+		if err == nil {
+			log.Print("SERVER ", gameName+"/"+categoryId+"/"+subCategory.SubCategoryId, " finalized")
+		}
+	}
+	if err != nil {
+		log.Print("SERVER ", gameName+"/"+categoryId+"/"+subCategory.SubCategoryId, err.Error())
+	}
 }
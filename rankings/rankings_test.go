@@ -0,0 +1,194 @@
+package rankings
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ynoproject/ynorankings/common"
+	"github.com/ynoproject/ynorankings/config"
+	"github.com/ynoproject/ynorankings/database"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openTestStore migrates a SQLite database in a temporary directory and fills in the given source rows, and
+// forgets the leaderboards rebuilt by earlier tests.
+func openTestStore(t *testing.T, sourceRows string) (config.Config, *sql.DB, database.RankingStore) {
+	t.Helper()
+
+	watermarks = make(map[string]string)
+	componentRebuilds = make(map[string]int)
+	currentEventPeriods = make(map[int]bool)
+	updateTotals = make(map[string]common.UpdateStats)
+
+	path := filepath.Join(t.TempDir(), "rankings.db")
+
+	cfg := config.Default()
+	cfg.Database.Driver = "sqlite"
+	cfg.Database.Dsn = path
+	cfg.SnapshotRetention = 0
+	cfg.UpdateConcurrency = 2
+
+	store := database.Init(cfg)
+	if err := store.MigrateUp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := conn.Exec(sourceRows); err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg, conn, store
+}
+
+func TestCategoryTreesOnlyListOwnSubCategories(t *testing.T) {
+	cfg, _, store := openTestStore(t, `
+		INSERT INTO accounts (uuid, user) VALUES ('u1', 'alice'), ('u2', 'bob');
+		INSERT INTO players (uuid) VALUES ('u1'), ('u2');
+		INSERT INTO badges (badgeId, game, bp) VALUES ('b1', '2kki', 10), ('b2', 'flow', 20);
+		INSERT INTO playerBadges VALUES ('u1', 'b1', '2024-01-01 10:00:00'), ('u2', 'b2', '2024-01-02 10:00:00');
+		INSERT INTO eventPeriods VALUES (1, 1, '2024-01-01', '2024-03-01'), (2, 2, '2024-03-01', '2099-01-01');
+		INSERT INTO gameEventPeriods VALUES (1, '2kki', 1, 1), (2, '2kki', 2, 1);
+		INSERT INTO gameLocations VALUES (1, 0);
+		INSERT INTO eventLocations VALUES (1, 1, 1), (2, 2, 1);
+		INSERT INTO eventCompletions VALUES (1, 'u1', 0, 3, '2024-01-05 00:00:00'), (2, 'u1', 0, 3, '2024-03-05 00:00:00');
+	`)
+	ctx := context.Background()
+
+	common.GameNames = []string{"2kki", "flow"}
+	common.GameRankingCategories = make(map[string][]*common.RankingCategory)
+	workers = make(chan struct{}, cfg.UpdateConcurrency)
+
+	writeRankingCategories(ctx, store, nil)
+	updateRankings(cfg, store, "")
+
+	for _, gameName := range common.GameNames {
+		rankingCategories, err := store.GetRankingCategories(ctx, gameName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var streakCategories []string
+		for _, category := range rankingCategories {
+			if strings.HasPrefix(category.CategoryId, "eventStreak") {
+				streakCategories = append(streakCategories, category.CategoryId)
+				continue
+			}
+			for _, subCategory := range category.SubCategories {
+				if subCategory.Game != "" && subCategory.Game != gameName {
+					t.Errorf("%s: %s lists %s of %s", gameName, category.CategoryId, subCategory.SubCategoryId, subCategory.Game)
+				}
+				if subCategory.SubCategoryId == "longest" || subCategory.SubCategoryId == "current" {
+					t.Errorf("%s: %s lists the %s streak", gameName, category.CategoryId, subCategory.SubCategoryId)
+				}
+			}
+		}
+
+		switch gameName {
+		case "2kki":
+			if len(streakCategories) != 1 || streakCategories[0] != "eventStreak_2kki" {
+				t.Errorf("2kki: got streak categories %v, want [eventStreak_2kki]", streakCategories)
+			}
+		case "flow":
+			if len(streakCategories) != 0 {
+				t.Errorf("flow has no event periods but lists %v", streakCategories)
+			}
+		}
+	}
+}
+
+func TestUpdateRankingsSkipsGamesWithUnchangedSources(t *testing.T) {
+	cfg, conn, store := openTestStore(t, `
+		INSERT INTO accounts (uuid, user) VALUES ('u1', 'alice'), ('u2', 'bob');
+		INSERT INTO players (uuid) VALUES ('u1'), ('u2');
+		INSERT INTO badges (badgeId, game, bp) VALUES ('b1', '2kki', 10), ('b2', 'flow', 20);
+		INSERT INTO playerBadges VALUES ('u1', 'b1', '2024-01-01 10:00:00'), ('u2', 'b2', '2024-01-02 10:00:00');
+	`)
+	ctx := context.Background()
+
+	common.GameNames = []string{"2kki", "flow"}
+	common.GameRankingCategories = make(map[string][]*common.RankingCategory)
+	workers = make(chan struct{}, cfg.UpdateConcurrency)
+
+	writeRankingCategories(ctx, store, nil)
+	updateRankings(cfg, store, "")
+
+	rebuilt := GetUpdateStats()["rankings"].Rebuilt
+	if rebuilt == 0 {
+		t.Fatal("nothing was rebuilt on the first run")
+	}
+
+	updateRankings(cfg, store, "")
+
+	stats := GetUpdateStats()["rankings"]
+	if stats.Runs != 2 || stats.Rebuilt != rebuilt || stats.Skipped != rebuilt {
+		t.Errorf("got %+v after an unchanged run, want every one of %d leaderboards skipped", stats, rebuilt)
+	}
+
+	before := maps.Clone(watermarks)
+	if _, err := conn.Exec("INSERT INTO playerBadges VALUES ('u1', 'b2', '2024-01-03 10:00:00')"); err != nil {
+		t.Fatal(err)
+	}
+
+	updateRankings(cfg, store, "")
+
+	for _, key := range []string{"bp/all", "bp/flow", "badgeCount/flow", "firstUnlocks_flow/first"} {
+		if watermarks[key] == before[key] {
+			t.Errorf("%s wasn't rebuilt after a flow badge was unlocked", key)
+		}
+	}
+	for _, key := range []string{"bp/2kki", "badgeCount/2kki", "firstUnlocks_2kki/first"} {
+		if watermarks[key] != before[key] {
+			t.Errorf("%s was rebuilt after a flow badge was unlocked", key)
+		}
+	}
+}
+
+func TestSharedLeaderboardsWithout2kki(t *testing.T) {
+	cfg, _, store := openTestStore(t, `
+		INSERT INTO accounts (uuid, user) VALUES ('u1', 'alice');
+		INSERT INTO players (uuid) VALUES ('u1');
+		INSERT INTO badges (badgeId, game, bp) VALUES ('b1', 'yume', 10);
+		INSERT INTO playerBadges VALUES ('u1', 'b1', '2024-01-01 10:00:00');
+		INSERT INTO eventPeriods VALUES (1, 1, '2024-01-01', '2024-03-01'), (2, 2, '2024-03-01', '2099-01-01');
+		INSERT INTO gameEventPeriods VALUES (1, 'yume', 1, 0), (2, 'yume', 2, 0);
+		INSERT INTO gameLocations VALUES (1, 0);
+		INSERT INTO eventLocations VALUES (1, 1, 1), (2, 2, 1);
+		INSERT INTO eventCompletions VALUES (1, 'u1', 0, 3, '2024-01-05 00:00:00'), (2, 'u1', 0, 3, '2024-03-05 00:00:00');
+	`)
+	ctx := context.Background()
+
+	common.GameNames = []string{"flow", "yume"}
+	common.GameRankingCategories = make(map[string][]*common.RankingCategory)
+	workers = make(chan struct{}, cfg.UpdateConcurrency)
+
+	writeRankingCategories(ctx, store, nil)
+	updateRankings(cfg, store, "")
+
+	rankingCategories, err := store.GetRankingCategories(ctx, "yume")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subCategories := make(map[string]bool)
+	for _, category := range rankingCategories {
+		for _, subCategory := range category.SubCategories {
+			subCategories[category.CategoryId+"/"+subCategory.SubCategoryId] = true
+		}
+	}
+	for _, name := range []string{"bp/all", "exp/all", "exp/1", "exp/2", "eventLocationCount/all"} {
+		if !subCategories[name] {
+			t.Errorf("yume doesn't list %s", name)
+		}
+	}
+}